@@ -0,0 +1,373 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/tarutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ManifestName is the first entry of every bundle
+	ManifestName = "bundle.json"
+	// SignatureExt is appended to the bundle path to find its detached signature
+	SignatureExt = ".sig"
+)
+
+// Manifest describes a bundle with every regular file and link in it. Directories are not listed,
+// they carry no content to verify.
+type Manifest struct {
+	Name     string                 `json:"name"`
+	Version  string                 `json:"version"`
+	Metadata map[string]string      `json:"metadata,omitempty"`
+	Files    []tarutil.FileChecksum `json:"files"`
+	Links    []tarutil.Link         `json:"links,omitempty"`
+}
+
+// File returns the manifest entry of name
+func (m *Manifest) File(name string) (tarutil.FileChecksum, bool) {
+	i := sort.Search(len(m.Files), func(i int) bool { return m.Files[i].Name >= name })
+	if i < len(m.Files) && m.Files[i].Name == name {
+		return m.Files[i], true
+	}
+	return tarutil.FileChecksum{}, false
+}
+
+// Link returns the manifest entry of the link name
+func (m *Manifest) Link(name string) (tarutil.Link, bool) {
+	i := sort.Search(len(m.Links), func(i int) bool { return m.Links[i].Name >= name })
+	if i < len(m.Links) && m.Links[i].Name == name {
+		return m.Links[i], true
+	}
+	return tarutil.Link{}, false
+}
+
+// Size returns the total size of the files
+func (m *Manifest) Size() int64 {
+	var size int64
+	for _, f := range m.Files {
+		size += f.Size
+	}
+	return size
+}
+
+// Create writes the files of b as a bundle to filename, the manifest is generated from the files
+// with the name, version and metadata of m and returned. The manifest is added to b as its first entry.
+func Create(filename string, b *tarutil.Builder, m Manifest) (*Manifest, error) {
+
+	files, err := b.Files()
+	if err != nil {
+		return nil, err
+	}
+	m.Files = make([]tarutil.FileChecksum, 0, len(files))
+	for _, f := range files {
+		if f.Name != ManifestName {
+			m.Files = append(m.Files, f)
+		}
+	}
+	m.Links = b.Links()
+
+	data, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := b.AddFile(ManifestName, append(data, '\n'), 0); err != nil {
+		return nil, err
+	}
+	b.Leading = append([]string{ManifestName}, b.Leading...)
+	if err := b.WriteFile(filename); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Bundle is an opened bundle file
+type Bundle struct {
+	Path     string
+	Manifest *Manifest
+	// manifest is the raw manifest the signature covers
+	manifest []byte
+	// leading are the entries before the files, the manifest and the optional checksums
+	leading []string
+}
+
+// Open reads the manifest of the bundle file, which has to be its first entry after the optional
+// checksums of tarutil.Builder
+func Open(filename string) (*Bundle, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s failed", filename)
+	}
+	defer f.Close()
+
+	dr, _, err := tarutil.DecompressReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	leading := []string{ManifestName}
+	reader := tar.NewReader(dr)
+	header, err := reader.Next()
+	if err == nil && header.Name == tarutil.ManifestName {
+		// a builder with checksums enabled writes them first
+		leading = append(leading, tarutil.ManifestName)
+		header, err = reader.Next()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s failed", filename)
+	}
+	if header.Name != ManifestName {
+		return nil, fmt.Errorf("%s is not a bundle, its first entry is %s", filename, header.Name)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read manifest of %s failed", filename)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest in %s", filename)
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name })
+	sort.Slice(m.Links, func(i, j int) bool { return m.Links[i].Name < m.Links[j].Name })
+	return &Bundle{Path: filename, Manifest: m, manifest: data, leading: leading}, nil
+}
+
+// VerifyError lists every file which does not match the manifest
+type VerifyError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("bundle %s is corrupt:\n\t%s", e.Path, strings.Join(e.Problems, "\n\t"))
+}
+
+// verifier checks files and links against the manifest as they are read
+type verifier struct {
+	manifest *Manifest
+	seen     map[string]bool
+	// leading are the entries Open read before the files, which are skipped once. Later entries of
+	// the same name are not part of the manifest.
+	leading map[string]bool
+}
+
+func (b *Bundle) newVerifier() *verifier {
+	v := &verifier{manifest: b.Manifest, seen: make(map[string]bool), leading: make(map[string]bool)}
+	for _, name := range b.leading {
+		v.leading[name] = true
+	}
+	return v
+}
+
+func (v *verifier) verify(name string, size int64, sum string) error {
+	// the manifest and the checksums tarutil.Builder writes before it are not listed
+	if v.leading[name] {
+		delete(v.leading, name)
+		return nil
+	}
+	v.seen[name] = true
+	f, ok := v.manifest.File(name)
+	switch {
+	case !ok:
+		return fmt.Errorf("%s is not listed in the manifest", name)
+	case f.Size != size:
+		return fmt.Errorf("%s has %d bytes, expected %d", name, size, f.Size)
+	case f.SHA256 != sum:
+		return fmt.Errorf("%s has sha256 %s, expected %s", name, sum, f.SHA256)
+	}
+	return nil
+}
+
+// verifyLink checks a symlink or hardlink, links not listed in the manifest are rejected as they
+// could point to any file
+func (v *verifier) verifyLink(link tarutil.Link) error {
+	v.seen[link.Name] = true
+	l, ok := v.manifest.Link(link.Name)
+	switch {
+	case !ok:
+		return fmt.Errorf("link %s is not listed in the manifest", link.Name)
+	case l.Hard != link.Hard:
+		return fmt.Errorf("%s is a %s, expected a %s", link.Name, linkType(link.Hard), linkType(l.Hard))
+	case link.Hard && path.Clean(link.Linkname) != path.Clean(l.Linkname), !link.Hard && link.Linkname != l.Linkname:
+		return fmt.Errorf("%s links to %s, expected %s", link.Name, link.Linkname, l.Linkname)
+	}
+	return nil
+}
+
+func linkType(hard bool) string {
+	if hard {
+		return "hardlink"
+	}
+	return "symlink"
+}
+
+// missing returns the files and links of the manifest not seen, which are selected by the filter
+func (v *verifier) missing(selected func(name string) bool) []string {
+	missing := make([]string, 0)
+	for _, f := range v.manifest.Files {
+		if !v.seen[f.Name] && selected(f.Name) {
+			missing = append(missing, fmt.Sprintf("%s is missing", f.Name))
+		}
+	}
+	for _, l := range v.manifest.Links {
+		if !v.seen[l.Name] && selected(l.Name) {
+			missing = append(missing, fmt.Sprintf("%s is missing", l.Name))
+		}
+	}
+	return missing
+}
+
+// Verify reads the whole bundle and checks every file and link against the manifest, it returns a
+// *VerifyError listing all mismatches, missing and unexpected entries
+func (b *Bundle) Verify() error {
+
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return errors.Wrapf(err, "open %s failed", b.Path)
+	}
+	defer f.Close()
+	dr, _, err := tarutil.DecompressReader(f)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	v := b.newVerifier()
+	problems := make([]string, 0)
+	reader := tar.NewReader(dr)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// a truncated bundle ends here, the files not read yet are reported missing
+			problems = append(problems, fmt.Sprintf("read failed: %v", err))
+			break
+		}
+		name := path.Clean(header.Name)
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+			link := tarutil.Link{Name: name, Linkname: header.Linkname, Hard: header.Typeflag == tar.TypeLink}
+			if err := v.verifyLink(link); err != nil {
+				problems = append(problems, err.Error())
+			}
+			continue
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		h := sha256.New()
+		size, err := io.Copy(h, reader)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("read %s failed: %v", header.Name, err))
+			break
+		}
+		if err := v.verify(name, size, fmt.Sprintf("%x", h.Sum(nil))); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	problems = append(problems, v.missing(func(string) bool { return true })...)
+	if len(problems) > 0 {
+		return &VerifyError{Path: b.Path, Problems: problems}
+	}
+	return nil
+}
+
+// ExtractTo unpacks the bundle into dir and verifies every file while it is written. The first file
+// not matching the manifest aborts the extraction and is removed, files written before it are kept.
+// Links are checked before they are created. Files of the manifest missing in the archive fail the
+// extraction once it is done.
+func (b *Bundle) ExtractTo(dir string, opts tarutil.ExtractOptions) error {
+
+	v := b.newVerifier()
+	verifyFile := opts.VerifyFile
+	opts.VerifyFile = func(name string, size int64, sum string) error {
+		if err := v.verify(name, size, sum); err != nil {
+			return &VerifyError{Path: b.Path, Problems: []string{err.Error()}}
+		}
+		if verifyFile != nil {
+			return verifyFile(name, size, sum)
+		}
+		return nil
+	}
+	verifyLink := opts.VerifyLink
+	opts.VerifyLink = func(link tarutil.Link) error {
+		if err := v.verifyLink(link); err != nil {
+			return &VerifyError{Path: b.Path, Problems: []string{err.Error()}}
+		}
+		if verifyLink != nil {
+			return verifyLink(link)
+		}
+		return nil
+	}
+	if err := tarutil.ExtractTo(b.Path, dir, opts); err != nil {
+		return err
+	}
+
+	missing := v.missing(func(name string) bool { return tarutil.Selected(name, opts.Include, opts.Exclude) })
+	if len(missing) > 0 {
+		return &VerifyError{Path: b.Path, Problems: missing}
+	}
+	return nil
+}
+
+// SignaturePath returns where the detached signature of the bundle is expected
+func (b *Bundle) SignaturePath() string {
+	return b.Path + SignatureExt
+}
+
+// Sign writes a detached signature of the manifest to SignaturePath, the manifest lists the
+// checksums of all files so it covers the whole content. The signature is equivalent to
+// "openssl dgst -sha256 -sign key.pem bundle.json".
+func (b *Bundle) Sign(key crypto.Signer) error {
+
+	digest := sha256.Sum256(b.manifest)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return errors.Wrapf(err, "sign %s failed", b.Path)
+	}
+	return ioutil.WriteFile(b.SignaturePath(), signature, 0644)
+}
+
+// VerifySignature checks the detached signature at SignaturePath against the manifest, keys are
+// loaded with pkiutil.TryLoadPublicKeyFromDisk or pkiutil.TryLoadPublicKeyFromString. It does not
+// read the files, Verify or ExtractTo check them against the signed manifest.
+func (b *Bundle) VerifySignature(key crypto.PublicKey) error {
+
+	signature, err := ioutil.ReadFile(b.SignaturePath())
+	if err != nil {
+		return errors.Wrapf(err, "read signature of %s failed", b.Path)
+	}
+	digest := sha256.Sum256(b.manifest)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			err = errors.New("verification error")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "invalid signature of %s", b.Path)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/tarutil"
+)
+
+// appendEntries rewrites the bundle with extra entries after its content
+func appendEntries(t *testing.T, filename string, extra []*tar.Header) {
+	t.Helper()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content := new(bytes.Buffer)
+		if _, err := content.ReadFrom(tr); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content.Bytes())
+	}
+	for _, header := range extra {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			tw.Write(bytes.Repeat([]byte("x"), int(header.Size)))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// checksums makes the builder write its SHA256SUMS before the manifest
+		checksums bool
+		extra     []*tar.Header
+		// problem is part of the expected VerifyError, empty if the bundle is intact
+		problem string
+	}{
+		{name: "intact"},
+		{name: "intact with checksums", checksums: true},
+		{
+			name:    "second manifest",
+			extra:   []*tar.Header{{Name: ManifestName, Typeflag: tar.TypeReg, Size: 1, Mode: 0644}},
+			problem: "bundle.json is not listed in the manifest",
+		},
+		{
+			name:      "second checksums",
+			checksums: true,
+			extra:     []*tar.Header{{Name: tarutil.ManifestName, Typeflag: tar.TypeReg, Size: 1, Mode: 0644}},
+			problem:   "SHA256SUMS is not listed in the manifest",
+		},
+		{
+			name:    "unlisted symlink",
+			extra:   []*tar.Header{{Name: "etc/passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+			problem: "link etc/passwd is not listed in the manifest",
+		},
+		{
+			name:    "changed symlink",
+			extra:   []*tar.Header{{Name: "bin/latest", Typeflag: tar.TypeSymlink, Linkname: "../../usr/bin/tool"}},
+			problem: "bin/latest links to ../../usr/bin/tool, expected tool",
+		},
+		{
+			name:    "unlisted hardlink",
+			extra:   []*tar.Header{{Name: "bin/copy", Typeflag: tar.TypeLink, Linkname: "bin/tool"}},
+			problem: "link bin/copy is not listed in the manifest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tarutil.NewBuilder()
+			b.Manifest = tt.checksums
+			if err := b.AddFile("bin/tool", []byte("#!/bin/sh\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.AddSymlink("bin/latest", "tool"); err != nil {
+				t.Fatal(err)
+			}
+			filename := filepath.Join(t.TempDir(), "test.tar")
+			if _, err := Create(filename, b, Manifest{Name: "test", Version: "1.0"}); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.extra) > 0 {
+				appendEntries(t, filename, tt.extra)
+			}
+
+			bundle, err := Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, err := range []error{bundle.Verify(), bundle.ExtractTo(t.TempDir(), tarutil.ExtractOptions{})} {
+				if tt.problem == "" {
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+					continue
+				}
+				var verifyErr *VerifyError
+				if !errors.As(err, &verifyErr) || !strings.Contains(err.Error(), tt.problem) {
+					t.Errorf("expected a VerifyError with %q, got %v", tt.problem, err)
+				}
+			}
+		})
+	}
+}
@@ -29,7 +29,7 @@ func NewKeyMutex() keyMutex {
 	}
 }
 
-func (mm keyMutex) LockKey(key string) bool {
+func (mm *keyMutex) LockKey(key string) bool {
 	mm.mux.Lock()
 	defer mm.mux.Unlock()
 	if mm.locks.Has(key) {
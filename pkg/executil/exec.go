@@ -0,0 +1,80 @@
+package executil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Executor runs external commands, so code driving host tools can be tested with FakeExecutor
+type Executor interface {
+	// Run executes name with args and returns its stdout. stdin is fed to the command if not nil.
+	// A command exiting with non-zero status returns an *ExitError carrying its stderr.
+	Run(stdin io.Reader, name string, args ...string) ([]byte, error)
+
+	// LookPath searches for an executable named name in the directories of PATH
+	LookPath(name string) (string, error)
+}
+
+// ExitError reports a command which ran but exited with non-zero status
+type ExitError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s exited with status %d", e.Command, e.ExitCode)
+	}
+	return fmt.Sprintf("%s exited with status %d: %s", e.Command, e.ExitCode, e.Stderr)
+}
+
+// ExitCode returns the exit status carried by err, or -1 if err is not an *ExitError
+func ExitCode(err error) int {
+	if exitErr, ok := err.(*ExitError); ok {
+		return exitErr.ExitCode
+	}
+	return -1
+}
+
+type osExecutor struct{}
+
+// New returns an Executor running commands on the host
+func New() Executor {
+	return &osExecutor{}
+}
+
+func (*osExecutor) Run(stdin io.Reader, name string, args ...string) ([]byte, error) {
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return stdout.Bytes(), &ExitError{
+			Command:  CommandLine(name, args...),
+			ExitCode: exitErr.ExitCode(),
+			Stderr:   strings.TrimSpace(stderr.String()),
+		}
+	}
+	if err != nil {
+		return stdout.Bytes(), fmt.Errorf("failed to run %s: %v", CommandLine(name, args...), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (*osExecutor) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+// CommandLine joins name and args with spaces, for logging and matching
+func CommandLine(name string, args ...string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
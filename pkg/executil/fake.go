@@ -0,0 +1,117 @@
+package executil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// FakeCall records a command run through FakeExecutor
+type FakeCall struct {
+	Name  string
+	Args  []string
+	Stdin string
+}
+
+func (c FakeCall) String() string {
+	return CommandLine(c.Name, c.Args...)
+}
+
+// FakeResult is what FakeExecutor returns for a command
+type FakeResult struct {
+	Stdout string
+	Err    error
+}
+
+// FakeExecutor is an Executor for tests, it records every call and replies with scripted results
+type FakeExecutor struct {
+	mu sync.Mutex
+
+	// Calls lists every command run, in order
+	Calls []FakeCall
+
+	// Results maps a command line prefix to its result, the longest matching prefix wins.
+	// Commands without a matching prefix succeed with empty output.
+	Results map[string]FakeResult
+
+	// Paths maps executables to the path LookPath returns, nil means every executable is found under /usr/bin
+	Paths map[string]string
+}
+
+var _ Executor = &FakeExecutor{}
+
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		Results: make(map[string]FakeResult),
+	}
+}
+
+// SetResult scripts the result of every command line starting with prefix
+func (f *FakeExecutor) SetResult(prefix, stdout string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Results == nil {
+		f.Results = make(map[string]FakeResult)
+	}
+	f.Results[prefix] = FakeResult{Stdout: stdout, Err: err}
+}
+
+// SetExitCode scripts every command line starting with prefix to fail with code and stderr
+func (f *FakeExecutor) SetExitCode(prefix string, code int, stderr string) {
+	f.SetResult(prefix, "", &ExitError{Command: prefix, ExitCode: code, Stderr: stderr})
+}
+
+// CommandLines returns the recorded calls as command lines
+func (f *FakeExecutor) CommandLines() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := make([]string, 0, len(f.Calls))
+	for _, c := range f.Calls {
+		lines = append(lines, c.String())
+	}
+	return lines
+}
+
+func (f *FakeExecutor) Run(stdin io.Reader, name string, args ...string) ([]byte, error) {
+
+	call := FakeCall{Name: name, Args: args}
+	if stdin != nil {
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		call.Stdin = string(data)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Calls = append(f.Calls, call)
+
+	line := call.String()
+	matched := ""
+	for prefix := range f.Results {
+		if strings.HasPrefix(line, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+		}
+	}
+	if matched == "" {
+		return nil, nil
+	}
+	result := f.Results[matched]
+	return []byte(result.Stdout), result.Err
+}
+
+func (f *FakeExecutor) LookPath(name string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Paths == nil {
+		return "/usr/bin/" + name, nil
+	}
+	if p, ok := f.Paths[name]; ok {
+		return p, nil
+	}
+	return "", fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+}
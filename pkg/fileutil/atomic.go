@@ -0,0 +1,45 @@
+package fileutil
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to filename with the given permissions, see WriteAtomic
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return WriteAtomic(filename, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteAtomic replaces filename with what write produces. The content goes to a temporary file in the same
+// directory which is synced and renamed over filename, so readers never see a partial file and a failing
+// write leaves the previous file in place
+func WriteAtomic(filename string, perm os.FileMode, write func(w io.Writer) error) error {
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
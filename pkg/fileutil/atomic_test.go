@@ -0,0 +1,51 @@
+package fileutil
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAtomic(t *testing.T) {
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "state.json")
+
+	if err := WriteFileAtomic(filename, []byte("v1"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("got mode %v, want 0640", info.Mode().Perm())
+	}
+
+	// a failing write keeps the previous content and cleans up the temporary file
+	failed := errors.New("failed")
+	err = WriteAtomic(filename, 0640, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected the error of write, got %v", err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "v1" {
+		t.Errorf("got %q, want v1", data)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only %s to be left, got %d files", filename, len(entries))
+	}
+}
@@ -0,0 +1,258 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/**
+幂等地管理 iptables / nftables 中的链和规则，如：VIP 的 NAT 规则，kube 组件的端口放行规则
+*/
+
+type BackendType string
+
+const (
+	BackendIPTables BackendType = "iptables"
+	BackendNFTables BackendType = "nftables"
+)
+
+type IPFamily int
+
+const (
+	IPv4 IPFamily = 4
+	IPv6 IPFamily = 6
+)
+
+type Table string
+
+const (
+	TableFilter Table = "filter"
+	TableNAT    Table = "nat"
+	TableMangle Table = "mangle"
+	TableRaw    Table = "raw"
+)
+
+// builtin chains, a chain with one of these names is hooked into packet processing
+const (
+	ChainPrerouting  = "PREROUTING"
+	ChainInput       = "INPUT"
+	ChainForward     = "FORWARD"
+	ChainOutput      = "OUTPUT"
+	ChainPostrouting = "POSTROUTING"
+)
+
+type Protocol string
+
+const (
+	ProtocolTCP Protocol = "tcp"
+	ProtocolUDP Protocol = "udp"
+)
+
+type Action string
+
+const (
+	ActionAccept     Action = "ACCEPT"
+	ActionDrop       Action = "DROP"
+	ActionReturn     Action = "RETURN"
+	ActionJump       Action = "JUMP"
+	ActionDNAT       Action = "DNAT"
+	ActionSNAT       Action = "SNAT"
+	ActionMasquerade Action = "MASQUERADE"
+)
+
+// Rule matches packets on the set fields and applies Action to them
+type Rule struct {
+	Protocol     Protocol
+	Source       *net.IPNet
+	Destination  *net.IPNet
+	DestPort     uint16
+	InInterface  string
+	OutInterface string
+
+	Action Action
+	// Target is the chain to jump to for ActionJump
+	Target string
+	// ToAddress and ToPort are the translated address for ActionDNAT and ActionSNAT, ToPort is optional
+	ToAddress net.IP
+	ToPort    uint16
+
+	Comment string
+}
+
+// Chain is a chain with its complete list of rules
+type Chain struct {
+	Table Table
+	Name  string
+	Rules []Rule
+}
+
+// Ruleset is the desired state of a set of chains
+type Ruleset struct {
+	Chains []Chain
+}
+
+// Interface manages chains and rules of one ip family. Every method is idempotent.
+type Interface interface {
+	// Type returns the kernel api the rules are written to
+	Type() BackendType
+
+	// EnsureChain creates chain in table if it does not exist
+	EnsureChain(table Table, chain string) error
+
+	// DeleteChain flushes and deletes chain, deleting an absent chain is not an error
+	DeleteChain(table Table, chain string) error
+
+	// EnsureRule appends rule to chain if it is not there yet, it returns true if the rule was added
+	EnsureRule(table Table, chain string, rule Rule) (bool, error)
+
+	// DeleteRule deletes rule from chain, deleting an absent rule is not an error
+	DeleteRule(table Table, chain string, rule Rule) error
+
+	// Reconcile makes the chains of rs hold exactly their rules.
+	// Builtin chains of the iptables backend are append-only, as those chains are shared with other users:
+	// their rules are ensured, rules dropped from rs are only removed by Delete with the previous ruleset.
+	Reconcile(rs *Ruleset) error
+
+	// Delete removes every chain and rule of rs
+	Delete(rs *Ruleset) error
+}
+
+// IsBuiltinChain returns true if name is one of the chains hooked into packet processing
+func IsBuiltinChain(name string) bool {
+	switch name {
+	case ChainPrerouting, ChainInput, ChainForward, ChainOutput, ChainPostrouting:
+		return true
+	}
+	return false
+}
+
+// Validate checks that the fields of r are consistent for family
+func (r Rule) Validate(family IPFamily) error {
+
+	if r.DestPort != 0 && r.Protocol == "" {
+		return fmt.Errorf("destination port requires a protocol")
+	}
+	for _, n := range []*net.IPNet{r.Source, r.Destination} {
+		if n != nil && familyOf(n.IP) != family {
+			return fmt.Errorf("network %s is not an IPv%d network", n, family)
+		}
+	}
+
+	switch r.Action {
+	case ActionAccept, ActionDrop, ActionReturn, ActionMasquerade:
+	case ActionJump:
+		if r.Target == "" {
+			return fmt.Errorf("jump requires a target chain")
+		}
+	case ActionDNAT, ActionSNAT:
+		if r.ToAddress == nil {
+			return fmt.Errorf("%s requires a translated address", r.Action)
+		}
+		if familyOf(r.ToAddress) != family {
+			return fmt.Errorf("address %s is not an IPv%d address", r.ToAddress, family)
+		}
+		if r.ToPort != 0 && r.Protocol == "" {
+			return fmt.Errorf("translated port requires a protocol")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	return nil
+}
+
+// Args returns r as iptables rule-specification, e.g. "-p tcp --dport 6443 -j ACCEPT".
+// The arguments identify a rule for both backends.
+func (r Rule) Args() []string {
+
+	args := make([]string, 0)
+	if r.Protocol != "" {
+		args = append(args, "-p", string(r.Protocol))
+	}
+	if r.Source != nil {
+		args = append(args, "-s", r.Source.String())
+	}
+	if r.Destination != nil {
+		args = append(args, "-d", r.Destination.String())
+	}
+	if r.InInterface != "" {
+		args = append(args, "-i", r.InInterface)
+	}
+	if r.OutInterface != "" {
+		args = append(args, "-o", r.OutInterface)
+	}
+	if r.DestPort != 0 {
+		args = append(args, "-m", string(r.Protocol), "--dport", strconv.Itoa(int(r.DestPort)))
+	}
+	if r.Comment != "" {
+		args = append(args, "-m", "comment", "--comment", r.Comment)
+	}
+
+	switch r.Action {
+	case ActionJump:
+		args = append(args, "-j", r.Target)
+	case ActionDNAT:
+		args = append(args, "-j", "DNAT", "--to-destination", hostPort(r.ToAddress, r.ToPort))
+	case ActionSNAT:
+		args = append(args, "-j", "SNAT", "--to-source", hostPort(r.ToAddress, r.ToPort))
+	default:
+		args = append(args, "-j", string(r.Action))
+	}
+	return args
+}
+
+func (r Rule) String() string {
+	return strings.Join(r.Args(), " ")
+}
+
+// Detect returns the backend in use on the host. iptables 1.8+ reports "(nf_tables)" in its
+// version when it is a frontend of nftables, hosts without iptables are assumed to run nftables
+// if the nft tool is installed.
+func Detect(exec executil.Executor) (BackendType, error) {
+
+	if _, err := exec.LookPath("iptables"); err == nil {
+		out, err := exec.Run(nil, "iptables", "--version")
+		if err != nil {
+			return "", fmt.Errorf("failed to get iptables version: %v", err)
+		}
+		if strings.Contains(string(out), "nf_tables") {
+			return BackendNFTables, nil
+		}
+		return BackendIPTables, nil
+	}
+
+	if _, err := exec.LookPath("nft"); err == nil {
+		return BackendNFTables, nil
+	}
+	return "", fmt.Errorf("neither iptables nor nftables is available")
+}
+
+func familyOf(ip net.IP) IPFamily {
+	if ip.To4() != nil {
+		return IPv4
+	}
+	return IPv6
+}
+
+func hostPort(ip net.IP, port uint16) string {
+	if port == 0 {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+func validateRuleset(rs *Ruleset, family IPFamily) error {
+	for _, c := range rs.Chains {
+		if c.Name == "" {
+			return fmt.Errorf("chain in table %s has no name", c.Table)
+		}
+		for _, r := range c.Rules {
+			if err := r.Validate(family); err != nil {
+				return fmt.Errorf("invalid rule %q in chain %s: %v", r, c.Name, err)
+			}
+		}
+	}
+	return nil
+}
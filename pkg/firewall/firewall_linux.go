@@ -0,0 +1,19 @@
+package firewall
+
+import (
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+// New returns the backend detected on the host for family.
+// nftables rules are written into the tables "<DefaultNFTablesPrefix>-<table>".
+func New(exec executil.Executor, family IPFamily) (Interface, error) {
+
+	backend, err := Detect(exec)
+	if err != nil {
+		return nil, err
+	}
+	if backend == BackendNFTables {
+		return NewNFTables(family, DefaultNFTablesPrefix), nil
+	}
+	return NewIPTables(exec, family), nil
+}
@@ -0,0 +1,225 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// iptablesBackend writes rules with the iptables tools, batches are applied atomically per table with iptables-restore
+type iptablesBackend struct {
+	exec   executil.Executor
+	family IPFamily
+}
+
+var _ Interface = &iptablesBackend{}
+
+func NewIPTables(exec executil.Executor, family IPFamily) Interface {
+	return &iptablesBackend{
+		exec:   exec,
+		family: family,
+	}
+}
+
+func (ipt *iptablesBackend) Type() BackendType {
+	return BackendIPTables
+}
+
+func (ipt *iptablesBackend) command() string {
+	if ipt.family == IPv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+func (ipt *iptablesBackend) run(table Table, args ...string) ([]byte, error) {
+	fullArgs := append([]string{"-w", "-t", string(table)}, args...)
+	return ipt.exec.Run(nil, ipt.command(), fullArgs...)
+}
+
+func (ipt *iptablesBackend) EnsureChain(table Table, chain string) error {
+
+	if IsBuiltinChain(chain) {
+		return nil
+	}
+	if _, err := ipt.run(table, "-N", chain); err != nil {
+		if isExists(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "create chain %s in table %s", chain, table)
+	}
+	return nil
+}
+
+func (ipt *iptablesBackend) DeleteChain(table Table, chain string) error {
+
+	if _, err := ipt.run(table, "-F", chain); err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "flush chain %s in table %s", chain, table)
+	}
+	if IsBuiltinChain(chain) {
+		return nil
+	}
+	if _, err := ipt.run(table, "-X", chain); err != nil && !isNotFound(err) {
+		return errors.Wrapf(err, "delete chain %s in table %s", chain, table)
+	}
+	return nil
+}
+
+func (ipt *iptablesBackend) hasRule(table Table, chain string, rule Rule) (bool, error) {
+
+	_, err := ipt.run(table, append([]string{"-C", chain}, rule.Args()...)...)
+	if err == nil {
+		return true, nil
+	}
+	// -C exits with 1 when the rule does not exist, and with 1 or 2 when the chain does not exist
+	if executil.ExitCode(err) == 1 || isNotFound(err) {
+		return false, nil
+	}
+	return false, errors.Wrapf(err, "check rule %q in chain %s", rule, chain)
+}
+
+func (ipt *iptablesBackend) EnsureRule(table Table, chain string, rule Rule) (bool, error) {
+
+	if err := rule.Validate(ipt.family); err != nil {
+		return false, err
+	}
+	exists, err := ipt.hasRule(table, chain, rule)
+	if err != nil || exists {
+		return false, err
+	}
+	if _, err := ipt.run(table, append([]string{"-A", chain}, rule.Args()...)...); err != nil {
+		return false, errors.Wrapf(err, "append rule %q to chain %s", rule, chain)
+	}
+	return true, nil
+}
+
+func (ipt *iptablesBackend) DeleteRule(table Table, chain string, rule Rule) error {
+
+	exists, err := ipt.hasRule(table, chain, rule)
+	if err != nil || !exists {
+		return err
+	}
+	if _, err := ipt.run(table, append([]string{"-D", chain}, rule.Args()...)...); err != nil {
+		return errors.Wrapf(err, "delete rule %q from chain %s", rule, chain)
+	}
+	return nil
+}
+
+// Reconcile rewrites the custom chains of rs in one iptables-restore batch, declaring a chain in a
+// --noflush batch flushes it. Rules of builtin chains are ensured afterwards, so jumps only appear
+// once their target chains exist. Builtin chains are append-only: they are shared with other users,
+// so a rule dropped from rs stays until Delete is called with the ruleset which added it.
+func (ipt *iptablesBackend) Reconcile(rs *Ruleset) error {
+
+	if err := validateRuleset(rs, ipt.family); err != nil {
+		return err
+	}
+
+	if batch := ipt.restoreBatch(rs); batch != "" {
+		if _, err := ipt.exec.Run(strings.NewReader(batch), ipt.command()+"-restore", "--noflush", "-w"); err != nil {
+			return errors.Wrapf(err, "restore rules")
+		}
+	}
+
+	for _, c := range rs.Chains {
+		if !IsBuiltinChain(c.Name) {
+			continue
+		}
+		for _, r := range c.Rules {
+			if _, err := ipt.EnsureRule(c.Table, c.Name, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ipt *iptablesBackend) restoreBatch(rs *Ruleset) string {
+
+	tables := make([]Table, 0)
+	chains := make(map[Table][]Chain)
+	for _, c := range rs.Chains {
+		if IsBuiltinChain(c.Name) {
+			continue
+		}
+		if _, ok := chains[c.Table]; !ok {
+			tables = append(tables, c.Table)
+		}
+		chains[c.Table] = append(chains[c.Table], c)
+	}
+
+	buf := new(bytes.Buffer)
+	for _, table := range tables {
+		fmt.Fprintf(buf, "*%s\n", table)
+		for _, c := range chains[table] {
+			fmt.Fprintf(buf, ":%s - [0:0]\n", c.Name)
+		}
+		for _, c := range chains[table] {
+			for _, r := range c.Rules {
+				fmt.Fprintf(buf, "-A %s %s\n", c.Name, quoteArgs(r.Args()))
+			}
+		}
+		buf.WriteString("COMMIT\n")
+	}
+	return buf.String()
+}
+
+// Delete removes the rules of the builtin chains first, so the custom chains are no longer referenced
+func (ipt *iptablesBackend) Delete(rs *Ruleset) error {
+
+	for _, c := range rs.Chains {
+		if !IsBuiltinChain(c.Name) {
+			continue
+		}
+		for _, r := range c.Rules {
+			if err := ipt.DeleteRule(c.Table, c.Name, r); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range rs.Chains {
+		if IsBuiltinChain(c.Name) {
+			continue
+		}
+		if _, err := ipt.run(c.Table, "-F", c.Name); err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "flush chain %s in table %s", c.Name, c.Table)
+		}
+	}
+	for _, c := range rs.Chains {
+		if IsBuiltinChain(c.Name) {
+			continue
+		}
+		if _, err := ipt.run(c.Table, "-X", c.Name); err != nil && !isNotFound(err) {
+			return errors.Wrapf(err, "delete chain %s in table %s", c.Name, c.Table)
+		}
+	}
+	return nil
+}
+
+// quoteArgs joins args for iptables-restore, which splits on spaces unless quoted
+func quoteArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"'") {
+			a = `"` + strings.ReplaceAll(a, `"`, `\"`) + `"`
+		}
+		quoted = append(quoted, a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func isExists(err error) bool {
+	exitErr, ok := err.(*executil.ExitError)
+	return ok && strings.Contains(exitErr.Stderr, "already exists")
+}
+
+func isNotFound(err error) bool {
+	exitErr, ok := err.(*executil.ExitError)
+	return ok && (strings.Contains(exitErr.Stderr, "No chain/target/match by that name") ||
+		strings.Contains(exitErr.Stderr, "does not exist"))
+}
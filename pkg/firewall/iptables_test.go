@@ -0,0 +1,205 @@
+package firewall
+
+import (
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestIPTablesReconcile(t *testing.T) {
+
+	ruleset := func(t *testing.T) *Ruleset {
+		return &Ruleset{Chains: []Chain{
+			{Table: TableFilter, Name: "KUBE-ALLOW", Rules: []Rule{
+				{Protocol: ProtocolTCP, DestPort: 6443, Action: ActionAccept, Comment: "kube apiserver"},
+				{Source: mustCIDR(t, "10.244.0.0/16"), Action: ActionAccept},
+			}},
+			{Table: TableNAT, Name: "VIP-DNAT", Rules: []Rule{
+				{Protocol: ProtocolTCP, Destination: mustCIDR(t, "192.168.1.100/32"), DestPort: 443,
+					Action: ActionDNAT, ToAddress: net.ParseIP("192.168.1.10"), ToPort: 6443},
+			}},
+			{Table: TableFilter, Name: ChainInput, Rules: []Rule{
+				{Action: ActionJump, Target: "KUBE-ALLOW"},
+			}},
+		}}
+	}
+	batch := "*filter\n" +
+		":KUBE-ALLOW - [0:0]\n" +
+		"-A KUBE-ALLOW -p tcp -m tcp --dport 6443 -m comment --comment \"kube apiserver\" -j ACCEPT\n" +
+		"-A KUBE-ALLOW -s 10.244.0.0/16 -j ACCEPT\n" +
+		"COMMIT\n" +
+		"*nat\n" +
+		":VIP-DNAT - [0:0]\n" +
+		"-A VIP-DNAT -p tcp -d 192.168.1.100/32 -m tcp --dport 443 -j DNAT --to-destination 192.168.1.10:6443\n" +
+		"COMMIT\n"
+
+	tests := []struct {
+		name    string
+		family  IPFamily
+		ruleset func(t *testing.T) *Ruleset
+		// script prepares the results of the fake executor
+		script  func(f *executil.FakeExecutor)
+		want    []executil.FakeCall
+		wantErr bool
+	}{
+		{
+			name:    "jump is missing",
+			family:  IPv4,
+			ruleset: ruleset,
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("iptables -w -t filter -C INPUT", 1, "iptables: Bad rule (does a matching rule exist in that chain?).")
+			},
+			want: []executil.FakeCall{
+				{Name: "iptables-restore", Args: []string{"--noflush", "-w"}, Stdin: batch},
+				{Name: "iptables", Args: []string{"-w", "-t", "filter", "-C", "INPUT", "-j", "KUBE-ALLOW"}},
+				{Name: "iptables", Args: []string{"-w", "-t", "filter", "-A", "INPUT", "-j", "KUBE-ALLOW"}},
+			},
+		},
+		{
+			name:    "jump exists",
+			family:  IPv4,
+			ruleset: ruleset,
+			want: []executil.FakeCall{
+				{Name: "iptables-restore", Args: []string{"--noflush", "-w"}, Stdin: batch},
+				{Name: "iptables", Args: []string{"-w", "-t", "filter", "-C", "INPUT", "-j", "KUBE-ALLOW"}},
+			},
+		},
+		{
+			name:   "ipv6 uses ip6tables",
+			family: IPv6,
+			ruleset: func(t *testing.T) *Ruleset {
+				return &Ruleset{Chains: []Chain{
+					{Table: TableFilter, Name: "KUBE-ALLOW", Rules: []Rule{{Source: mustCIDR(t, "fd00::/64"), Action: ActionAccept}}},
+				}}
+			},
+			want: []executil.FakeCall{
+				{Name: "ip6tables-restore", Args: []string{"--noflush", "-w"},
+					Stdin: "*filter\n:KUBE-ALLOW - [0:0]\n-A KUBE-ALLOW -s fd00::/64 -j ACCEPT\nCOMMIT\n"},
+			},
+		},
+		{
+			name:   "only builtin chains",
+			family: IPv4,
+			ruleset: func(t *testing.T) *Ruleset {
+				return &Ruleset{Chains: []Chain{
+					{Table: TableNAT, Name: ChainPostrouting, Rules: []Rule{{OutInterface: "eth0", Action: ActionMasquerade}}},
+				}}
+			},
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("iptables -w -t nat -C", 1, "")
+			},
+			want: []executil.FakeCall{
+				{Name: "iptables", Args: []string{"-w", "-t", "nat", "-C", "POSTROUTING", "-o", "eth0", "-j", "MASQUERADE"}},
+				{Name: "iptables", Args: []string{"-w", "-t", "nat", "-A", "POSTROUTING", "-o", "eth0", "-j", "MASQUERADE"}},
+			},
+		},
+		{
+			name:   "invalid rule",
+			family: IPv4,
+			ruleset: func(t *testing.T) *Ruleset {
+				return &Ruleset{Chains: []Chain{
+					{Table: TableFilter, Name: "KUBE-ALLOW", Rules: []Rule{{Source: mustCIDR(t, "fd00::/64"), Action: ActionAccept}}},
+				}}
+			},
+			want:    []executil.FakeCall{},
+			wantErr: true,
+		},
+		{
+			name:    "restore fails",
+			family:  IPv4,
+			ruleset: ruleset,
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("iptables-restore", 2, "iptables-restore: line 3 failed")
+			},
+			want: []executil.FakeCall{
+				{Name: "iptables-restore", Args: []string{"--noflush", "-w"}, Stdin: batch},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			if tt.script != nil {
+				tt.script(fake)
+			}
+			err := NewIPTables(fake, tt.family).Reconcile(tt.ruleset(t))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			calls := fake.Calls
+			if calls == nil {
+				calls = []executil.FakeCall{}
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("calls:\n%v\nwant:\n%v", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPTablesDelete(t *testing.T) {
+
+	fake := executil.NewFakeExecutor()
+	// the custom chain is gone already, deleting it again is not an error
+	fake.SetExitCode("iptables -w -t filter -F KUBE-ALLOW", 1, "iptables: No chain/target/match by that name.")
+	fake.SetExitCode("iptables -w -t filter -X KUBE-ALLOW", 1, "iptables: No chain/target/match by that name.")
+
+	rs := &Ruleset{Chains: []Chain{
+		{Table: TableFilter, Name: "KUBE-ALLOW", Rules: []Rule{{Action: ActionAccept}}},
+		{Table: TableFilter, Name: ChainInput, Rules: []Rule{{Action: ActionJump, Target: "KUBE-ALLOW"}}},
+	}}
+	if err := NewIPTables(fake, IPv4).Delete(rs); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"iptables -w -t filter -C INPUT -j KUBE-ALLOW",
+		"iptables -w -t filter -D INPUT -j KUBE-ALLOW",
+		"iptables -w -t filter -F KUBE-ALLOW",
+		"iptables -w -t filter -X KUBE-ALLOW",
+	}
+	if got := fake.CommandLines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestDetect(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		paths   map[string]string
+		version string
+		want    BackendType
+		wantErr bool
+	}{
+		{name: "legacy iptables", paths: map[string]string{"iptables": "/usr/sbin/iptables"}, version: "iptables v1.8.4 (legacy)", want: BackendIPTables},
+		{name: "iptables-nft", paths: map[string]string{"iptables": "/usr/sbin/iptables"}, version: "iptables v1.8.7 (nf_tables)", want: BackendNFTables},
+		{name: "nft only", paths: map[string]string{"nft": "/usr/sbin/nft"}, want: BackendNFTables},
+		{name: "nothing", paths: map[string]string{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			fake.Paths = tt.paths
+			fake.SetResult("iptables --version", tt.version, nil)
+			got, err := Detect(fake)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Detect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Detect() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
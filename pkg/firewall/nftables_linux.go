@@ -0,0 +1,488 @@
+package firewall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+const (
+	DefaultNFTablesPrefix = "gosdk"
+
+	// userdata tlv type nft uses for rule comments
+	nftUserDataComment = 0
+	// nft limits comments to 128 bytes including the terminating zero
+	nftMaxCommentLen = 127
+)
+
+// nftBackend writes rules over netlink into tables owned by this package, one per iptables table
+// named "<prefix>-<table>". Builtin chain names become base chains hooked like their iptables
+// counterparts. Rules are identified by their iptables arguments, stored as nft rule comment.
+type nftBackend struct {
+	family IPFamily
+	prefix string
+	netNS  int
+}
+
+var _ Interface = &nftBackend{}
+
+// NewNFTables returns a backend writing into the tables "<prefix>-<table>"
+func NewNFTables(family IPFamily, prefix string) Interface {
+	return &nftBackend{
+		family: family,
+		prefix: prefix,
+	}
+}
+
+// NewNFTablesInNetNS works like NewNFTables in the network namespace referenced by the file descriptor nsFd
+func NewNFTablesInNetNS(family IPFamily, prefix string, nsFd int) Interface {
+	return &nftBackend{
+		family: family,
+		prefix: prefix,
+		netNS:  nsFd,
+	}
+}
+
+func (nft *nftBackend) Type() BackendType {
+	return BackendNFTables
+}
+
+func (nft *nftBackend) conn() (*nftables.Conn, error) {
+	if nft.netNS != 0 {
+		return nftables.New(nftables.WithNetNSFd(nft.netNS))
+	}
+	return nftables.New()
+}
+
+func (nft *nftBackend) tableFamily() nftables.TableFamily {
+	if nft.family == IPv6 {
+		return nftables.TableFamilyIPv6
+	}
+	return nftables.TableFamilyIPv4
+}
+
+func (nft *nftBackend) table(table Table) *nftables.Table {
+	return &nftables.Table{
+		Name:   fmt.Sprintf("%s-%s", nft.prefix, table),
+		Family: nft.tableFamily(),
+	}
+}
+
+// chain returns the nft chain for name, builtin names are base chains with the hook and priority iptables uses
+func (nft *nftBackend) chain(t *nftables.Table, table Table, name string) *nftables.Chain {
+
+	c := &nftables.Chain{Name: name, Table: t}
+	if !IsBuiltinChain(name) {
+		return c
+	}
+
+	c.Type = nftables.ChainTypeFilter
+	switch name {
+	case ChainPrerouting:
+		c.Hooknum = nftables.ChainHookPrerouting
+	case ChainInput:
+		c.Hooknum = nftables.ChainHookInput
+	case ChainForward:
+		c.Hooknum = nftables.ChainHookForward
+	case ChainOutput:
+		c.Hooknum = nftables.ChainHookOutput
+	case ChainPostrouting:
+		c.Hooknum = nftables.ChainHookPostrouting
+	}
+
+	switch table {
+	case TableNAT:
+		c.Type = nftables.ChainTypeNAT
+		c.Priority = nftables.ChainPriorityNATDest
+		if name == ChainPostrouting || name == ChainInput {
+			c.Priority = nftables.ChainPriorityNATSource
+		}
+	case TableMangle:
+		c.Priority = nftables.ChainPriorityMangle
+		if name == ChainOutput {
+			c.Type = nftables.ChainTypeRoute
+		}
+	case TableRaw:
+		c.Priority = nftables.ChainPriorityRaw
+	default:
+		c.Priority = nftables.ChainPriorityFilter
+	}
+	return c
+}
+
+func (nft *nftBackend) findChain(conn *nftables.Conn, t *nftables.Table, name string) (*nftables.Chain, error) {
+	chains, err := conn.ListChainsOfTableFamily(t.Family)
+	if err != nil {
+		return nil, errors.Wrap(err, "list nftables chains")
+	}
+	for _, c := range chains {
+		if c.Table.Name == t.Name && c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (nft *nftBackend) EnsureChain(table Table, chain string) error {
+
+	conn, err := nft.conn()
+	if err != nil {
+		return err
+	}
+	t := conn.AddTable(nft.table(table))
+	conn.AddChain(nft.chain(t, table, chain))
+	if err := conn.Flush(); err != nil {
+		return errors.Wrapf(err, "create chain %s in table %s", chain, t.Name)
+	}
+	return nil
+}
+
+func (nft *nftBackend) DeleteChain(table Table, chain string) error {
+
+	conn, err := nft.conn()
+	if err != nil {
+		return err
+	}
+	t := nft.table(table)
+	c, err := nft.findChain(conn, t, chain)
+	if err != nil || c == nil {
+		return err
+	}
+	conn.FlushChain(c)
+	conn.DelChain(c)
+	if err := conn.Flush(); err != nil {
+		return errors.Wrapf(err, "delete chain %s in table %s", chain, t.Name)
+	}
+	return nil
+}
+
+func (nft *nftBackend) findRule(conn *nftables.Conn, t *nftables.Table, c *nftables.Chain, rule Rule) (*nftables.Rule, error) {
+	rules, err := conn.GetRules(t, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list rules of chain %s", c.Name)
+	}
+	key := string(ruleUserData(rule))
+	for _, r := range rules {
+		if string(r.UserData) == key {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (nft *nftBackend) EnsureRule(table Table, chain string, rule Rule) (bool, error) {
+
+	if err := rule.Validate(nft.family); err != nil {
+		return false, err
+	}
+	exprs, err := nft.ruleExprs(rule)
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := nft.conn()
+	if err != nil {
+		return false, err
+	}
+	t := nft.table(table)
+	c, err := nft.findChain(conn, t, chain)
+	if err != nil {
+		return false, err
+	}
+	if c != nil {
+		existing, err := nft.findRule(conn, t, c, rule)
+		if err != nil || existing != nil {
+			return false, err
+		}
+	} else {
+		t = conn.AddTable(t)
+		c = conn.AddChain(nft.chain(t, table, chain))
+	}
+
+	conn.AddRule(&nftables.Rule{Table: t, Chain: c, Exprs: exprs, UserData: ruleUserData(rule)})
+	if err := conn.Flush(); err != nil {
+		return false, errors.Wrapf(err, "append rule %q to chain %s", rule, chain)
+	}
+	return true, nil
+}
+
+func (nft *nftBackend) DeleteRule(table Table, chain string, rule Rule) error {
+
+	conn, err := nft.conn()
+	if err != nil {
+		return err
+	}
+	t := nft.table(table)
+	c, err := nft.findChain(conn, t, chain)
+	if err != nil || c == nil {
+		return err
+	}
+	existing, err := nft.findRule(conn, t, c, rule)
+	if err != nil || existing == nil {
+		return err
+	}
+	if err := conn.DelRule(existing); err != nil {
+		return errors.Wrapf(err, "delete rule %q from chain %s", rule, chain)
+	}
+	if err := conn.Flush(); err != nil {
+		return errors.Wrapf(err, "delete rule %q from chain %s", rule, chain)
+	}
+	return nil
+}
+
+// Reconcile replaces the content of the owned tables in a single netlink batch, chains of those
+// tables which are not part of rs are deleted.
+func (nft *nftBackend) Reconcile(rs *Ruleset) error {
+
+	if err := validateRuleset(rs, nft.family); err != nil {
+		return err
+	}
+
+	desired := make(map[string]map[string]bool)
+	for _, c := range rs.Chains {
+		name := nft.table(c.Table).Name
+		if desired[name] == nil {
+			desired[name] = make(map[string]bool)
+		}
+		desired[name][c.Name] = true
+	}
+
+	conn, err := nft.conn()
+	if err != nil {
+		return err
+	}
+	existing, err := conn.ListChainsOfTableFamily(nft.tableFamily())
+	if err != nil {
+		return errors.Wrap(err, "list nftables chains")
+	}
+
+	// flush every chain first, so no jump references a chain deleted below
+	for _, c := range existing {
+		if _, owned := desired[c.Table.Name]; owned {
+			conn.FlushChain(c)
+		}
+	}
+	for _, c := range existing {
+		if chains, owned := desired[c.Table.Name]; owned && !chains[c.Name] {
+			conn.DelChain(c)
+		}
+	}
+
+	// declare every chain before adding rules, so jumps can reference any chain
+	chains := make([]*nftables.Chain, len(rs.Chains))
+	for i, c := range rs.Chains {
+		t := conn.AddTable(nft.table(c.Table))
+		chains[i] = conn.AddChain(nft.chain(t, c.Table, c.Name))
+	}
+	for i, c := range rs.Chains {
+		for _, r := range c.Rules {
+			exprs, err := nft.ruleExprs(r)
+			if err != nil {
+				return err
+			}
+			conn.AddRule(&nftables.Rule{Table: chains[i].Table, Chain: chains[i], Exprs: exprs, UserData: ruleUserData(r)})
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return errors.Wrap(err, "apply nftables ruleset")
+	}
+	return nil
+}
+
+// Delete removes the chains of rs, and the owned tables once they are empty
+func (nft *nftBackend) Delete(rs *Ruleset) error {
+
+	conn, err := nft.conn()
+	if err != nil {
+		return err
+	}
+	existing, err := conn.ListChainsOfTableFamily(nft.tableFamily())
+	if err != nil {
+		return errors.Wrap(err, "list nftables chains")
+	}
+
+	remove := make(map[string]map[string]bool)
+	for _, c := range rs.Chains {
+		name := nft.table(c.Table).Name
+		if remove[name] == nil {
+			remove[name] = make(map[string]bool)
+		}
+		remove[name][c.Name] = true
+	}
+
+	remaining := make(map[string]int)
+	tables := make(map[string]*nftables.Table)
+	for _, c := range existing {
+		if _, owned := remove[c.Table.Name]; !owned {
+			continue
+		}
+		tables[c.Table.Name] = c.Table
+		if remove[c.Table.Name][c.Name] {
+			conn.FlushChain(c)
+		} else {
+			remaining[c.Table.Name]++
+		}
+	}
+	for _, c := range existing {
+		if chains, owned := remove[c.Table.Name]; owned && chains[c.Name] {
+			conn.DelChain(c)
+		}
+	}
+	for name, t := range tables {
+		if remaining[name] == 0 {
+			conn.DelTable(t)
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return errors.Wrap(err, "delete nftables ruleset")
+	}
+	return nil
+}
+
+// ruleExprs translates rule into nftables expressions, in the order nft itself generates them
+func (nft *nftBackend) ruleExprs(rule Rule) ([]expr.Any, error) {
+
+	exprs := make([]expr.Any, 0)
+
+	if rule.InInterface != "" {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(rule.InInterface)},
+		)
+	}
+	if rule.OutInterface != "" {
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(rule.OutInterface)},
+		)
+	}
+
+	srcOffset, dstOffset, addrLen := uint32(12), uint32(16), uint32(net.IPv4len)
+	if nft.family == IPv6 {
+		srcOffset, dstOffset, addrLen = 8, 24, net.IPv6len
+	}
+	if rule.Source != nil {
+		exprs = append(exprs, matchNetwork(rule.Source, srcOffset, addrLen)...)
+	}
+	if rule.Destination != nil {
+		exprs = append(exprs, matchNetwork(rule.Destination, dstOffset, addrLen)...)
+	}
+
+	if rule.Protocol != "" {
+		proto, err := protocolNumber(rule.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs,
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{proto}},
+		)
+	}
+	if rule.DestPort != 0 {
+		exprs = append(exprs,
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(rule.DestPort)},
+		)
+	}
+
+	natFamily := uint32(unix.NFPROTO_IPV4)
+	if nft.family == IPv6 {
+		natFamily = unix.NFPROTO_IPV6
+	}
+
+	switch rule.Action {
+	case ActionAccept:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictAccept})
+	case ActionDrop:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictDrop})
+	case ActionReturn:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictReturn})
+	case ActionJump:
+		exprs = append(exprs, &expr.Verdict{Kind: expr.VerdictJump, Chain: rule.Target})
+	case ActionMasquerade:
+		exprs = append(exprs, &expr.Masq{})
+	case ActionDNAT, ActionSNAT:
+		natType := expr.NATTypeDestNAT
+		if rule.Action == ActionSNAT {
+			natType = expr.NATTypeSourceNAT
+		}
+		addr := rule.ToAddress.To4()
+		if nft.family == IPv6 {
+			addr = rule.ToAddress.To16()
+		}
+		nat := &expr.NAT{Type: natType, Family: natFamily, RegAddrMin: 1}
+		exprs = append(exprs, &expr.Immediate{Register: 1, Data: addr})
+		if rule.ToPort != 0 {
+			exprs = append(exprs, &expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(rule.ToPort)})
+			nat.RegProtoMin = 2
+		}
+		exprs = append(exprs, nat)
+	default:
+		return nil, fmt.Errorf("unknown action %q", rule.Action)
+	}
+	return exprs, nil
+}
+
+func matchNetwork(n *net.IPNet, offset, addrLen uint32) []expr.Any {
+
+	ip := n.IP.To4()
+	mask := []byte(n.Mask)
+	if addrLen == net.IPv6len {
+		ip = n.IP.To16()
+	}
+	if len(mask) != int(addrLen) {
+		mask = mask[len(mask)-int(addrLen):]
+	}
+
+	exprs := []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: addrLen},
+	}
+	if ones, bits := n.Mask.Size(); ones != bits {
+		exprs = append(exprs, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            addrLen,
+			Mask:           mask,
+			Xor:            make([]byte, addrLen),
+		})
+	}
+	return append(exprs, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip.Mask(n.Mask)})
+}
+
+func protocolNumber(p Protocol) (byte, error) {
+	switch p {
+	case ProtocolTCP:
+		return unix.IPPROTO_TCP, nil
+	case ProtocolUDP:
+		return unix.IPPROTO_UDP, nil
+	}
+	return 0, fmt.Errorf("unknown protocol %q", p)
+}
+
+// ifname pads name like the kernel stores interface names
+func ifname(name string) []byte {
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return b
+}
+
+// ruleUserData encodes the identity of rule as nft comment, long rules are shortened with a digest
+func ruleUserData(rule Rule) []byte {
+	comment := rule.String()
+	if len(comment) > nftMaxCommentLen {
+		sum := sha256.Sum256([]byte(comment))
+		digest := hex.EncodeToString(sum[:8])
+		comment = comment[:nftMaxCommentLen-len(digest)-1] + "#" + digest
+	}
+	data := []byte{nftUserDataComment, byte(len(comment) + 1)}
+	data = append(data, comment...)
+	return append(data, 0)
+}
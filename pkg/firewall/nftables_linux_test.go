@@ -0,0 +1,135 @@
+package firewall
+
+import (
+	"github.com/google/nftables"
+	"github.com/vishvananda/netns"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// newTestNetNS creates a network namespace for the test without entering it
+func newTestNetNS(t *testing.T) netns.NsHandle {
+	t.Helper()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		t.Skipf("unable to get the network namespace: %v", err)
+	}
+	defer orig.Close()
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("unable to create a network namespace: %v", err)
+	}
+	if err := netns.Set(orig); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ns.Close() })
+
+	conn, err := nftables.New(nftables.WithNetNSFd(int(ns)))
+	if err == nil {
+		_, err = conn.ListTables()
+	}
+	if err != nil {
+		t.Skipf("nftables is not available: %v", err)
+	}
+	return ns
+}
+
+// listRules returns the rules of every chain of the tables owned by prefix, by "<table>/<chain>"
+func listRules(t *testing.T, ns netns.NsHandle, prefix string) map[string][]string {
+	t.Helper()
+
+	conn, err := nftables.New(nftables.WithNetNSFd(int(ns)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains, err := conn.ListChainsOfTableFamily(nftables.TableFamilyIPv4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := make(map[string][]string)
+	for _, c := range chains {
+		if !strings.HasPrefix(c.Table.Name, prefix+"-") {
+			continue
+		}
+		list, err := conn.GetRules(c.Table, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := c.Table.Name + "/" + c.Name
+		rules[key] = make([]string, 0, len(list))
+		for _, r := range list {
+			// the comment tlv holds the iptables arguments of the rule
+			rules[key] = append(rules[key], strings.TrimRight(string(r.UserData[2:]), "\x00"))
+		}
+	}
+	return rules
+}
+
+func TestNFTablesReconcile(t *testing.T) {
+
+	ns := newTestNetNS(t)
+	nft := NewNFTablesInNetNS(IPv4, "test", int(ns))
+
+	_, pods, _ := net.ParseCIDR("10.244.0.0/16")
+	apiserver := Rule{Protocol: ProtocolTCP, DestPort: 6443, Action: ActionAccept}
+	steps := []struct {
+		name    string
+		ruleset *Ruleset
+		want    map[string][]string
+	}{
+		{
+			name: "create",
+			ruleset: &Ruleset{Chains: []Chain{
+				{Table: TableFilter, Name: "KUBE-ALLOW", Rules: []Rule{apiserver, {Source: pods, Action: ActionAccept}}},
+				{Table: TableFilter, Name: ChainInput, Rules: []Rule{{Action: ActionJump, Target: "KUBE-ALLOW"}}},
+			}},
+			want: map[string][]string{
+				"test-filter/KUBE-ALLOW": {apiserver.String(), "-s 10.244.0.0/16 -j ACCEPT"},
+				"test-filter/INPUT":      {"-j KUBE-ALLOW"},
+			},
+		},
+		{
+			name: "replace rules and drop a chain",
+			ruleset: &Ruleset{Chains: []Chain{
+				{Table: TableFilter, Name: ChainInput, Rules: []Rule{apiserver}},
+			}},
+			want: map[string][]string{
+				"test-filter/INPUT": {apiserver.String()},
+			},
+		},
+		{
+			name: "add a table",
+			ruleset: &Ruleset{Chains: []Chain{
+				{Table: TableFilter, Name: ChainInput, Rules: []Rule{apiserver}},
+				{Table: TableNAT, Name: ChainPostrouting, Rules: []Rule{{OutInterface: "eth0", Action: ActionMasquerade}}},
+			}},
+			want: map[string][]string{
+				"test-filter/INPUT":    {apiserver.String()},
+				"test-nat/POSTROUTING": {"-o eth0 -j MASQUERADE"},
+			},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			if err := nft.Reconcile(step.ruleset); err != nil {
+				t.Fatal(err)
+			}
+			if got := listRules(t, ns, "test"); !reflect.DeepEqual(got, step.want) {
+				t.Errorf("got %v, want %v", got, step.want)
+			}
+		})
+	}
+
+	if err := nft.Delete(steps[len(steps)-1].ruleset); err != nil {
+		t.Fatal(err)
+	}
+	if got := listRules(t, ns, "test"); len(got) != 0 {
+		t.Errorf("chains left after Delete: %v", got)
+	}
+}
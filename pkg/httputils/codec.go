@@ -16,7 +16,11 @@ type Codec interface {
 
 var _ Codec = (*JSONCodec)(nil)
 
-type JSONCodec struct{}
+// JSONCodec encodes requests and decodes responses as JSON, responses with a status code outside of
+// 2xx are returned as *HTTPError with the body decoded by ErrorDecoders, DefaultErrorDecoders if nil
+type JSONCodec struct {
+	ErrorDecoders []ErrorDecoder
+}
 
 func (d JSONCodec) Encode(ctx context.Context, url string, method string, content interface{}, callInfo *CallInfo) (*http.Request, error) {
 
@@ -57,8 +61,15 @@ func (d JSONCodec) Decode(res *http.Response, reply interface{}) error {
 	if err != nil {
 		return errors.Wrap(err, "error response")
 	}
-	if res.StatusCode < 200 && res.StatusCode >= 300 {
-		return errors.Wrapf(err, "error code %d, data: %s", res.StatusCode, string(data))
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		decoders := d.ErrorDecoders
+		if decoders == nil {
+			decoders = DefaultErrorDecoders
+		}
+		return NewHTTPError(res, data, decoders...)
+	}
+	if reply == nil || res.StatusCode == http.StatusNoContent {
+		return nil
 	}
 	err = json.Unmarshal(data, reply)
 	if err != nil {
@@ -0,0 +1,134 @@
+package httputils
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func response(code int, contentType, body string) *http.Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set(ContentTypeKey, contentType)
+	}
+	return &http.Response{StatusCode: code, Header: header, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestJSONCodecDecodeErrors(t *testing.T) {
+
+	long := strings.Repeat("x", maxErrorBody+100)
+	tests := []struct {
+		name        string
+		res         *http.Response
+		wantReason  string
+		wantMessage string
+		wantError   string
+		checkDetail func(detail interface{}) bool
+	}{
+		{
+			name:        "kubernetes status",
+			res:         response(http.StatusNotFound, ContentTypeJSON, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":"pods \"web\" not found","reason":"NotFound","code":404}`),
+			wantReason:  "NotFound",
+			wantMessage: `pods "web" not found`,
+			wantError:   `error code 404, pods "web" not found`,
+			checkDetail: func(detail interface{}) bool {
+				status, ok := detail.(*metav1.Status)
+				return ok && status.Code == http.StatusNotFound
+			},
+		},
+		{
+			name:        "problem details",
+			res:         response(http.StatusConflict, ContentTypeProblemJSON+"; charset=utf-8", `{"type":"about:blank","title":"Conflict","status":409,"detail":"version 3 is outdated"}`),
+			wantReason:  "Conflict",
+			wantMessage: "version 3 is outdated",
+			wantError:   "error code 409, version 3 is outdated",
+			checkDetail: func(detail interface{}) bool {
+				problem, ok := detail.(*Problem)
+				return ok && problem.Status == http.StatusConflict
+			},
+		},
+		{
+			name:       "unrecognized body",
+			res:        response(http.StatusInternalServerError, "text/plain", long),
+			wantReason: "Internal Server Error",
+			// only the first maxErrorBody bytes of the body are shown
+			wantError:   "error code 500, data: " + long[:maxErrorBody],
+			checkDetail: func(detail interface{}) bool { return detail == nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := JSONCodec{}.Decode(tt.res, nil)
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) {
+				t.Fatalf("expected an *HTTPError, got %v", err)
+			}
+			if httpErr.StatusCode != tt.res.StatusCode {
+				t.Errorf("got status code %d, want %d", httpErr.StatusCode, tt.res.StatusCode)
+			}
+			if httpErr.Reason != tt.wantReason {
+				t.Errorf("got reason %q, want %q", httpErr.Reason, tt.wantReason)
+			}
+			if httpErr.Message != tt.wantMessage {
+				t.Errorf("got message %q, want %q", httpErr.Message, tt.wantMessage)
+			}
+			if got := httpErr.Error(); got != tt.wantError {
+				t.Errorf("got error %q, want %q", got, tt.wantError)
+			}
+			if !tt.checkDetail(httpErr.Detail) {
+				t.Errorf("unexpected detail %#v", httpErr.Detail)
+			}
+		})
+	}
+}
+
+func TestJSONCodecDecodeReply(t *testing.T) {
+
+	// a 204 has no body to decode, even if the caller asks for a reply
+	reply := map[string]string{}
+	if err := (JSONCodec{}).Decode(response(http.StatusNoContent, "", ""), &reply); err != nil {
+		t.Errorf("204 with a reply: %v", err)
+	}
+	if err := (JSONCodec{}).Decode(response(http.StatusNoContent, "", ""), nil); err != nil {
+		t.Errorf("204 without a reply: %v", err)
+	}
+
+	if err := (JSONCodec{}).Decode(response(http.StatusOK, ContentTypeJSON, `{"name":"web"}`), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply["name"] != "web" {
+		t.Errorf("got %v, want name web", reply)
+	}
+}
+
+func TestIsStatusWrapped(t *testing.T) {
+
+	notFound := JSONCodec{}.Decode(response(http.StatusNotFound, "", "gone"), nil)
+	conflict := JSONCodec{}.Decode(response(http.StatusConflict, "", "taken"), nil)
+
+	tests := []struct {
+		name         string
+		err          error
+		wantNotFound bool
+		wantConflict bool
+	}{
+		{name: "not found", err: errors.Wrap(notFound, "get pod"), wantNotFound: true},
+		{name: "conflict", err: fmt.Errorf("update pod: %w", conflict), wantConflict: true},
+		{name: "other", err: errors.New("connection refused")},
+		{name: "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsNotFound(tt.err); got != tt.wantNotFound {
+				t.Errorf("IsNotFound() = %v, want %v", got, tt.wantNotFound)
+			}
+			if got := IsConflict(tt.err); got != tt.wantConflict {
+				t.Errorf("IsConflict() = %v, want %v", got, tt.wantConflict)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package httputils
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"mime"
+	"net/http"
+)
+
+const (
+	ContentTypeProblemJSON = "application/problem+json"
+
+	// maxErrorBody is how much of an undecoded error body HTTPError.Error shows
+	maxErrorBody = 512
+)
+
+// HTTPError is returned by Codec.Decode for responses with a status code outside of 2xx
+type HTTPError struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Reason and Message are filled by the ErrorDecoder recognizing the body, Detail is the decoded
+	// body, e.g. *metav1.Status or *Problem. They are empty if no decoder recognized it.
+	Reason  string
+	Message string
+	Detail  interface{}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("error code %d, %s", e.StatusCode, e.Message)
+	}
+	body := e.Body
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody]
+	}
+	return fmt.Sprintf("error code %d, data: %s", e.StatusCode, string(body))
+}
+
+// ErrorDecoder decodes the body of an error response into e, it returns false if it does not
+// recognize the body
+type ErrorDecoder func(e *HTTPError) bool
+
+// DefaultErrorDecoders are used by JSONCodec unless it is given its own
+var DefaultErrorDecoders = []ErrorDecoder{DecodeKubernetesStatus, DecodeProblem}
+
+// NewHTTPError builds the error of res with its already read body, the first decoder recognizing the
+// body fills Reason, Message and Detail
+func NewHTTPError(res *http.Response, body []byte, decoders ...ErrorDecoder) *HTTPError {
+	e := &HTTPError{StatusCode: res.StatusCode, Header: res.Header, Body: body}
+	for _, decode := range decoders {
+		if decode(e) {
+			break
+		}
+	}
+	if e.Reason == "" {
+		e.Reason = http.StatusText(e.StatusCode)
+	}
+	return e
+}
+
+// DecodeKubernetesStatus decodes the metav1.Status returned by the Kubernetes API server
+func DecodeKubernetesStatus(e *HTTPError) bool {
+	status := &metav1.Status{}
+	if err := json.Unmarshal(e.Body, status); err != nil || status.Kind != "Status" {
+		return false
+	}
+	e.Reason, e.Message, e.Detail = string(status.Reason), status.Message, status
+	return true
+}
+
+// Problem is the RFC 7807 problem details of an HTTP API error
+type Problem struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// DecodeProblem decodes RFC 7807 problem details, which are sent as application/problem+json
+func DecodeProblem(e *HTTPError) bool {
+	mediaType, _, err := mime.ParseMediaType(e.Header.Get(ContentTypeKey))
+	if err != nil || mediaType != ContentTypeProblemJSON {
+		return false
+	}
+	problem := &Problem{}
+	if err := json.Unmarshal(e.Body, problem); err != nil {
+		return false
+	}
+	e.Reason, e.Message, e.Detail = problem.Title, problem.Detail, problem
+	if e.Message == "" {
+		e.Message = problem.Title
+	}
+	return true
+}
+
+// StatusCode returns the status code of the HTTPError err wraps
+func StatusCode(err error) (int, bool) {
+	var e *HTTPError
+	if errors.As(err, &e) {
+		return e.StatusCode, true
+	}
+	return 0, false
+}
+
+// IsStatus returns true if err wraps an HTTPError with status code code
+func IsStatus(err error, code int) bool {
+	status, ok := StatusCode(err)
+	return ok && status == code
+}
+
+func IsBadRequest(err error) bool {
+	return IsStatus(err, http.StatusBadRequest)
+}
+
+func IsUnauthorized(err error) bool {
+	return IsStatus(err, http.StatusUnauthorized)
+}
+
+func IsForbidden(err error) bool {
+	return IsStatus(err, http.StatusForbidden)
+}
+
+func IsNotFound(err error) bool {
+	return IsStatus(err, http.StatusNotFound)
+}
+
+func IsConflict(err error) bool {
+	return IsStatus(err, http.StatusConflict)
+}
+
+func IsTooManyRequests(err error) bool {
+	return IsStatus(err, http.StatusTooManyRequests)
+}
+
+// IsServerError returns true if err wraps an HTTPError with a 5xx status code
+func IsServerError(err error) bool {
+	status, ok := StatusCode(err)
+	return ok && status >= 500 && status < 600
+}
@@ -0,0 +1,229 @@
+package imagearchive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/tarutil"
+)
+
+const ociLayoutVersion = "1.0.0"
+
+// OCI media types of docker layer media types
+var ociLayerMediaTypes = map[string]string{
+	"application/vnd.docker.image.rootfs.diff.tar":                 MediaTypeOCILayer,
+	"application/vnd.docker.image.rootfs.diff.tar.gzip":            MediaTypeOCILayer + "+gzip",
+	"application/vnd.docker.image.rootfs.diff.tar.zstd":            MediaTypeOCILayer + "+zstd",
+	"application/vnd.docker.image.rootfs.foreign.diff.tar.gzip":    "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip",
+	"application/vnd.oci.image.layer.nondistributable.v1.tar":      "application/vnd.oci.image.layer.nondistributable.v1.tar",
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+gzip": "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip",
+	"application/vnd.oci.image.layer.nondistributable.v1.tar+zstd": "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd",
+	MediaTypeOCILayer:           MediaTypeOCILayer,
+	MediaTypeOCILayer + "+gzip": MediaTypeOCILayer + "+gzip",
+	MediaTypeOCILayer + "+zstd": MediaTypeOCILayer + "+zstd",
+}
+
+// converter fills the digests of layers the source archive does not record, layers are read at most
+// once
+type converter struct {
+	r      *Reader
+	hashes map[string]*layerHashes
+}
+
+func (c *converter) hash(layer Layer) (*layerHashes, error) {
+	if h, ok := c.hashes[layer.Path]; ok {
+		return h, nil
+	}
+	h, err := c.r.hashLayerFile(layer)
+	if err != nil {
+		return nil, err
+	}
+	c.hashes[layer.Path] = h
+	return h, nil
+}
+
+func (c *converter) digest(layer Layer) (string, error) {
+	if layer.Digest != "" {
+		return layer.Digest, nil
+	}
+	h, err := c.hash(layer)
+	if err != nil {
+		return "", err
+	}
+	return h.digest, nil
+}
+
+func (c *converter) ociLayer(layer Layer) (*descriptor, error) {
+
+	desc := &descriptor{Digest: layer.Digest, Size: layer.Size, MediaType: ociLayerMediaTypes[layer.MediaType]}
+	if desc.Digest == "" || desc.Size < 0 || desc.MediaType == "" {
+		h, err := c.hash(layer)
+		if err != nil {
+			return nil, err
+		}
+		desc.Digest, desc.Size = h.digest, h.size
+		switch h.compression {
+		case tarutil.None:
+			desc.MediaType = MediaTypeOCILayer
+		case tarutil.Gzip, tarutil.Zstd:
+			desc.MediaType = MediaTypeOCILayer + "+" + string(h.compression)
+		default:
+			return nil, fmt.Errorf("layer %s is %s compressed, which OCI images do not support", layer.Path, h.compression)
+		}
+	}
+	return desc, nil
+}
+
+// ToOCI adds the images to b in the OCI image layout. Every name of an image becomes an entry of
+// index.json annotated with the full name and the tag, untagged images get an entry without names.
+func (r *Reader) ToOCI(b *tarutil.Builder) error {
+
+	c := &converter{r: r, hashes: make(map[string]*layerHashes)}
+	index := &ociIndex{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: make([]descriptor, 0)}
+	for _, image := range r.images {
+
+		info, err := fs.Stat(r.fsys, image.ConfigPath)
+		if err != nil {
+			return err
+		}
+		manifest := &ociManifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeOCIManifest,
+			Config:        descriptor{MediaType: MediaTypeOCIConfig, Digest: image.Config, Size: info.Size()},
+			Layers:        make([]descriptor, 0, len(image.Layers)),
+		}
+		if err := addBlob(b, r.fsys, image.ConfigPath, image.Config); err != nil {
+			return err
+		}
+		for _, layer := range image.Layers {
+			desc, err := c.ociLayer(layer)
+			if err != nil {
+				return err
+			}
+			if err := addBlob(b, r.fsys, layer.Path, desc.Digest); err != nil {
+				return err
+			}
+			manifest.Layers = append(manifest.Layers, *desc)
+		}
+
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return err
+		}
+		desc := descriptor{MediaType: MediaTypeOCIManifest, Digest: digestOf(data), Size: int64(len(data))}
+		manifestPath, err := blobPath(desc.Digest)
+		if err != nil {
+			return err
+		}
+		if err := b.AddFile(manifestPath, data, 0); err != nil {
+			return err
+		}
+		if image.OS != "" {
+			desc.Platform = &platform{OS: image.OS, Arch: image.Arch, Variant: image.Variant}
+		}
+		if len(image.RepoTags) == 0 {
+			index.Manifests = append(index.Manifests, desc)
+		}
+		for _, repoTag := range image.RepoTags {
+			tagged := desc
+			tagged.Annotations = map[string]string{AnnotationImageName: repoTag}
+			if _, tag := splitRepoTag(repoTag); tag != "" {
+				tagged.Annotations[AnnotationRefName] = tag
+			}
+			index.Manifests = append(index.Manifests, tagged)
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := b.AddFile(ociIndexFile, data, 0); err != nil {
+		return err
+	}
+	return b.AddFile(ociLayoutFile, []byte(fmt.Sprintf(`{"imageLayoutVersion":"%s"}`, ociLayoutVersion)), 0)
+}
+
+// ToDockerSave adds the images to b in the layout of docker save, which docker load of every docker
+// version accepts. Configs are named by their digest and layers by the digest of the layer file.
+func (r *Reader) ToDockerSave(b *tarutil.Builder) error {
+
+	c := &converter{r: r, hashes: make(map[string]*layerHashes)}
+	entries := make([]dockerManifestEntry, 0, len(r.images))
+	repositories := make(map[string]map[string]string)
+	for _, image := range r.images {
+
+		entry := dockerManifestEntry{
+			Config:   strings.TrimPrefix(image.Config, "sha256:") + ".json",
+			RepoTags: make([]string, 0, len(image.RepoTags)),
+			Layers:   make([]string, 0, len(image.Layers)),
+		}
+		if err := b.AddFromFS(entry.Config, r.fsys, image.ConfigPath, 0); err != nil {
+			return err
+		}
+		for _, layer := range image.Layers {
+			digest, err := c.digest(layer)
+			if err != nil {
+				return err
+			}
+			layerPath := path.Join(strings.TrimPrefix(digest, "sha256:"), "layer.tar")
+			if err := b.AddFromFS(layerPath, r.fsys, layer.Path, 0); err != nil {
+				return err
+			}
+			entry.Layers = append(entry.Layers, layerPath)
+		}
+
+		for _, repoTag := range image.RepoTags {
+			// docker save only writes names with a tag, digest references can not be loaded
+			if strings.Contains(repoTag, "@") {
+				continue
+			}
+			entry.RepoTags = append(entry.RepoTags, repoTag)
+			if len(entry.Layers) == 0 {
+				continue
+			}
+			repo, tag := splitRepoTag(repoTag)
+			if repositories[repo] == nil {
+				repositories[repo] = make(map[string]string)
+			}
+			repositories[repo][tag] = path.Dir(entry.Layers[len(entry.Layers)-1])
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := b.AddFile(dockerManifestFile, data, 0); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(repositories); err != nil {
+		return err
+	}
+	return b.AddFile(repositoriesFile, data, 0)
+}
+
+// addBlob adds the file src of fsys as the blob of digest
+func addBlob(b *tarutil.Builder, fsys fs.FS, src, digest string) error {
+	p, err := blobPath(digest)
+	if err != nil {
+		return err
+	}
+	return b.AddFromFS(p, fsys, src, 0)
+}
+
+// splitRepoTag splits an image name into repository and tag, names without a tag are "latest" and
+// digest references have no tag
+func splitRepoTag(repoTag string) (string, string) {
+	if i := strings.Index(repoTag, "@"); i >= 0 {
+		return repoTag[:i], ""
+	}
+	if i := strings.LastIndex(repoTag, ":"); i > strings.LastIndex(repoTag, "/") {
+		return repoTag[:i], repoTag[i+1:]
+	}
+	return repoTag, "latest"
+}
@@ -0,0 +1,560 @@
+package imagearchive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/tarutil"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Format is the layout of an image archive
+type Format string
+
+const (
+	// FormatDocker is the layout written by docker save before docker 25, manifest.json lists the
+	// images with their config and layer files
+	FormatDocker Format = "docker"
+	// FormatOCI is the OCI image layout, index.json points to manifests stored as content addressed
+	// blobs. Archives written by docker 25 and later have both index.json and manifest.json.
+	FormatOCI Format = "oci"
+)
+
+// files and media types of the two layouts
+const (
+	dockerManifestFile = "manifest.json"
+	repositoriesFile   = "repositories"
+	ociLayoutFile      = "oci-layout"
+	ociIndexFile       = "index.json"
+
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIConfig      = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer       = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// AnnotationRefName is the tag of an index.json entry, containerd and docker put the full image
+	// name into AnnotationImageName
+	AnnotationRefName   = "org.opencontainers.image.ref.name"
+	AnnotationImageName = "io.containerd.image.name"
+)
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Layer is a layer of an image
+type Layer struct {
+	// Digest is the sha256 of the layer file as stored, empty for docker save archives which do not
+	// record it
+	Digest    string `json:"digest,omitempty"`
+	MediaType string `json:"mediaType,omitempty"`
+	// DiffID is the sha256 of the uncompressed layer, taken from the image config
+	DiffID string `json:"diffID"`
+	// Size is the size of the layer file, -1 if the archive does not record it
+	Size int64 `json:"size"`
+	// Path is the file of the layer inside the archive
+	Path string `json:"path"`
+}
+
+// Image is an image found in the archive
+type Image struct {
+	// RepoTags are the names of the image, e.g. "nginx:1.23" or "docker.io/library/nginx:1.23"
+	RepoTags []string `json:"repoTags,omitempty"`
+	// Manifest is the digest of the OCI manifest, empty for docker save archives
+	Manifest string `json:"manifest,omitempty"`
+	// Config is the digest of the image config, ConfigPath its file inside the archive
+	Config     string  `json:"config"`
+	ConfigPath string  `json:"configPath"`
+	OS         string  `json:"os,omitempty"`
+	Arch       string  `json:"architecture,omitempty"`
+	Variant    string  `json:"variant,omitempty"`
+	Layers     []Layer `json:"layers"`
+}
+
+// Reader lists the images of a docker save or OCI layout archive
+type Reader struct {
+	Format Format
+	fsys   fs.FS
+	images []Image
+	// closers release the archive and the temporary directory compressed archives are unpacked to
+	closers []func() error
+}
+
+// DefaultExtractOptions bound what Open unpacks of compressed archives
+var DefaultExtractOptions = tarutil.ExtractOptions{
+	MaxEntries:   100000,
+	MaxFileSize:  16 << 30,
+	MaxTotalSize: 64 << 30,
+}
+
+// Open reads the image archive at filename. Uncompressed archives are read in place through a
+// tarutil index built in memory, nothing is written next to the archive. Compressed ones are unpacked
+// to a temporary directory first, which Close removes, opts limits what is unpacked, see
+// DefaultExtractOptions.
+func Open(filename string, opts tarutil.ExtractOptions) (*Reader, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s failed", filename)
+	}
+	header := make([]byte, 16)
+	n, _ := f.ReadAt(header, 0)
+	if tarutil.DetectCompression(header[:n]) == tarutil.None {
+		r, err := openIndexed(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "read %s failed", filename)
+		}
+		return r, nil
+	}
+	f.Close()
+	klog.V(4).Infof("unpack %s, it is compressed", filename)
+
+	dir, err := ioutil.TempDir("", "imagearchive-")
+	if err != nil {
+		return nil, err
+	}
+	if err := tarutil.ExtractTo(filename, dir, opts); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	r, err := NewReader(os.DirFS(dir))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, errors.Wrapf(err, "read %s failed", filename)
+	}
+	r.closers = append(r.closers, func() error { return os.RemoveAll(dir) })
+	return r, nil
+}
+
+// openIndexed reads the uncompressed archive f through an index, closing the reader closes f
+func openIndexed(f *os.File) (*Reader, error) {
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	index, err := tarutil.BuildIndex(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	archive, err := tarutil.NewArchive(f, info.Size(), index)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(archive)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+	r.closers = append(r.closers, archive.Close, f.Close)
+	return r, nil
+}
+
+// NewReader reads the images of the archive content in fsys, e.g. a tarutil.Archive or an unpacked
+// archive
+func NewReader(fsys fs.FS) (*Reader, error) {
+
+	r := &Reader{fsys: fsys}
+	_, ociErr := fs.Stat(fsys, ociIndexFile)
+	_, dockerErr := fs.Stat(fsys, dockerManifestFile)
+	var err error
+	switch {
+	case ociErr == nil:
+		r.Format = FormatOCI
+		r.images, err = r.readOCI()
+		if err == nil && dockerErr == nil {
+			err = r.mergeDockerTags()
+		}
+	case dockerErr == nil:
+		r.Format = FormatDocker
+		r.images, err = r.readDocker()
+	default:
+		return nil, fmt.Errorf("neither %s nor %s found", ociIndexFile, dockerManifestFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close releases the archive
+func (r *Reader) Close() error {
+	var err error
+	for _, closer := range r.closers {
+		if closeErr := closer(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Images returns the images in the order the archive lists them
+func (r *Reader) Images() []Image {
+	return r.images
+}
+
+// RepoTags returns the names of all images, sorted
+func (r *Reader) RepoTags() []string {
+	tags := make([]string, 0)
+	for _, image := range r.images {
+		tags = append(tags, image.RepoTags...)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Image returns the image named repoTag
+func (r *Reader) Image(repoTag string) (*Image, bool) {
+	for i := range r.images {
+		for _, tag := range r.images[i].RepoTags {
+			if tag == repoTag {
+				return &r.images[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ReadConfig returns the raw config of the image
+func (r *Reader) ReadConfig(image *Image) ([]byte, error) {
+	return fs.ReadFile(r.fsys, image.ConfigPath)
+}
+
+// OpenLayer opens the layer file as stored in the archive, it may be compressed
+func (r *Reader) OpenLayer(layer Layer) (fs.File, error) {
+	return r.fsys.Open(layer.Path)
+}
+
+func (r *Reader) readJSON(name string, v interface{}) error {
+	data, err := fs.ReadFile(r.fsys, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrapf(err, "invalid %s", name)
+	}
+	return nil
+}
+
+// blobPath returns the file of a blob of the OCI layout
+func blobPath(digest string) (string, error) {
+	if !digestPattern.MatchString(digest) {
+		return "", fmt.Errorf("unsupported digest %q", digest)
+	}
+	return path.Join("blobs", "sha256", strings.TrimPrefix(digest, "sha256:")), nil
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// imageConfig is the part of the image config the reader needs
+type imageConfig struct {
+	OS      string `json:"os"`
+	Arch    string `json:"architecture"`
+	Variant string `json:"variant"`
+	RootFS  struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// readConfig fills the platform of the image from its config and returns the diff ids of the layers
+func (r *Reader) readConfig(image *Image) ([]string, error) {
+	config := &imageConfig{}
+	if err := r.readJSON(image.ConfigPath, config); err != nil {
+		return nil, err
+	}
+	image.OS, image.Arch, image.Variant = config.OS, config.Arch, config.Variant
+	return config.RootFS.DiffIDs, nil
+}
+
+// dockerManifestEntry is an element of manifest.json
+type dockerManifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+func (r *Reader) readDocker() ([]Image, error) {
+
+	entries := make([]dockerManifestEntry, 0)
+	if err := r.readJSON(dockerManifestFile, &entries); err != nil {
+		return nil, err
+	}
+
+	repositories, err := r.readRepositories()
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]Image, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry.RepoTags) == 0 && len(entry.Layers) > 0 {
+			entry.RepoTags = repositories[path.Dir(entry.Layers[len(entry.Layers)-1])]
+		}
+		data, err := fs.ReadFile(r.fsys, entry.Config)
+		if err != nil {
+			return nil, err
+		}
+		image := Image{RepoTags: entry.RepoTags, Config: digestOf(data), ConfigPath: entry.Config}
+		diffIDs, err := r.readConfig(&image)
+		if err != nil {
+			return nil, err
+		}
+		if len(diffIDs) != len(entry.Layers) {
+			return nil, fmt.Errorf("config %s lists %d layers, %s %d", entry.Config, len(diffIDs), dockerManifestFile, len(entry.Layers))
+		}
+		for i, layerPath := range entry.Layers {
+			layer := Layer{DiffID: diffIDs[i], Size: -1, Path: layerPath}
+			// docker 25 stores layers as blobs named by their digest
+			if strings.HasPrefix(layerPath, "blobs/sha256/") {
+				layer.Digest = "sha256:" + path.Base(layerPath)
+			}
+			if info, err := fs.Stat(r.fsys, layerPath); err == nil {
+				layer.Size = info.Size()
+			}
+			image.Layers = append(image.Layers, layer)
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// readRepositories returns the names of the images by the id of their top layer. Old docker versions
+// only write the names to the repositories file, it maps repositories to tags to layer ids.
+func (r *Reader) readRepositories() (map[string][]string, error) {
+
+	repositories := make(map[string]map[string]string)
+	if err := r.readJSON(repositoriesFile, &repositories); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	tags := make(map[string][]string)
+	for repo, repoTags := range repositories {
+		for tag, id := range repoTags {
+			tags[id] = append(tags[id], repo+":"+tag)
+		}
+	}
+	for id := range tags {
+		sort.Strings(tags[id])
+	}
+	return tags, nil
+}
+
+// descriptor is an OCI content descriptor
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *platform         `json:"platform,omitempty"`
+}
+
+type platform struct {
+	OS      string `json:"os"`
+	Arch    string `json:"architecture"`
+	Variant string `json:"variant,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+func (r *Reader) readOCI() ([]Image, error) {
+	index := &ociIndex{}
+	if err := r.readJSON(ociIndexFile, index); err != nil {
+		return nil, err
+	}
+	return r.readOCIIndex(index, nil, 0)
+}
+
+// readOCIIndex reads the images of an index, nested indexes of multi platform images inherit the
+// names of their parent
+func (r *Reader) readOCIIndex(index *ociIndex, names []string, depth int) ([]Image, error) {
+
+	if depth > 8 {
+		return nil, fmt.Errorf("image indexes are nested too deep")
+	}
+	images := make([]Image, 0)
+	for _, desc := range index.Manifests {
+		repoTags := names
+		if name := refName(desc.Annotations); name != "" {
+			repoTags = []string{name}
+		}
+		p, err := blobPath(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		switch desc.MediaType {
+		case MediaTypeOCIIndex, MediaTypeDockerList:
+			nested := &ociIndex{}
+			if err := r.readJSON(p, nested); err != nil {
+				return nil, err
+			}
+			nestedImages, err := r.readOCIIndex(nested, repoTags, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, nestedImages...)
+		case MediaTypeOCIManifest, MediaTypeDockerManifest:
+			manifest := &ociManifest{}
+			if err := r.readJSON(p, manifest); err != nil {
+				if desc.Platform != nil && errors.Is(err, fs.ErrNotExist) {
+					// archives of a single platform keep the index of all platforms
+					klog.V(4).Infof("skip manifest %s of %s/%s which is not in the archive", desc.Digest, desc.Platform.OS, desc.Platform.Arch)
+					continue
+				}
+				return nil, err
+			}
+			image, err := r.readOCIManifest(desc.Digest, manifest)
+			if err != nil {
+				return nil, err
+			}
+			image.RepoTags = append([]string{}, repoTags...)
+			images = append(images, *image)
+		default:
+			klog.V(4).Infof("skip %s of unknown media type %s", desc.Digest, desc.MediaType)
+		}
+	}
+	return images, nil
+}
+
+func (r *Reader) readOCIManifest(digest string, manifest *ociManifest) (*Image, error) {
+
+	configPath, err := blobPath(manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{Manifest: digest, Config: manifest.Config.Digest, ConfigPath: configPath}
+	diffIDs, err := r.readConfig(image)
+	if err != nil {
+		return nil, err
+	}
+	if len(diffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("config %s lists %d layers, manifest %s %d", manifest.Config.Digest, len(diffIDs), digest, len(manifest.Layers))
+	}
+	for i, desc := range manifest.Layers {
+		layerPath, err := blobPath(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		image.Layers = append(image.Layers, Layer{
+			Digest:    desc.Digest,
+			MediaType: desc.MediaType,
+			DiffID:    diffIDs[i],
+			Size:      desc.Size,
+			Path:      layerPath,
+		})
+	}
+	return image, nil
+}
+
+// refName returns the image name of an index entry, the full name if it is annotated
+func refName(annotations map[string]string) string {
+	if name := annotations[AnnotationImageName]; name != "" {
+		return name
+	}
+	return annotations[AnnotationRefName]
+}
+
+// mergeDockerTags takes the names of images from manifest.json, docker writes short names there
+func (r *Reader) mergeDockerTags() error {
+	entries := make([]dockerManifestEntry, 0)
+	if err := r.readJSON(dockerManifestFile, &entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		config := "sha256:" + path.Base(entry.Config)
+		for i := range r.images {
+			if r.images[i].Config == config && len(r.images[i].RepoTags) == 0 {
+				r.images[i].RepoTags = entry.RepoTags
+			}
+		}
+	}
+	return nil
+}
+
+// layerHashes are the digests of a layer file as stored and of its decompressed content
+type layerHashes struct {
+	digest      string
+	diffID      string
+	size        int64
+	compression tarutil.Compression
+}
+
+// hashLayer reads the layer file once to compute its digest, diff id and size
+func hashLayer(f io.Reader) (*layerHashes, error) {
+
+	raw := sha256.New()
+	counter := &countingReader{r: io.TeeReader(f, raw)}
+	dr, compression, err := tarutil.DecompressReader(counter)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	uncompressed := sha256.New()
+	if _, err := io.Copy(uncompressed, dr); err != nil {
+		return nil, err
+	}
+	// decoders may stop before trailing data, the stored digest covers all of it
+	if _, err := io.Copy(ioutil.Discard, counter); err != nil {
+		return nil, err
+	}
+	h := &layerHashes{digest: "sha256:" + hex.EncodeToString(raw.Sum(nil)), size: counter.n, compression: compression}
+	h.diffID = h.digest
+	if compression != tarutil.None {
+		h.diffID = "sha256:" + hex.EncodeToString(uncompressed.Sum(nil))
+	}
+	return h, nil
+}
+
+// hashLayerFile hashes the layer file inside the archive
+func (r *Reader) hashLayerFile(layer Layer) (*layerHashes, error) {
+	f, err := r.OpenLayer(layer)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := hashLayer(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read layer %s failed", layer.Path)
+	}
+	return h, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
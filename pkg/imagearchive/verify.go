@@ -0,0 +1,74 @@
+package imagearchive
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// VerifyError lists every digest of the archive not matching its content
+type VerifyError struct {
+	Problems []string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("image archive is corrupt:\n\t%s", strings.Join(e.Problems, "\n\t"))
+}
+
+// Verify reads every manifest, config and layer of the archive and checks them against their digests,
+// sizes and the diff ids of the image configs. Layers shared by images are read once.
+func (r *Reader) Verify() error {
+
+	problems := make([]string, 0)
+	verified := make(map[string]bool)
+	for _, image := range r.images {
+		if image.Manifest != "" && !verified[image.Manifest] {
+			verified[image.Manifest] = true
+			if p, err := blobPath(image.Manifest); err != nil {
+				problems = append(problems, fmt.Sprintf("manifest: %v", err))
+			} else {
+				problems = append(problems, r.verifyBlob(p, image.Manifest)...)
+			}
+		}
+		if !verified[image.ConfigPath] {
+			verified[image.ConfigPath] = true
+			problems = append(problems, r.verifyBlob(image.ConfigPath, image.Config)...)
+		}
+
+		for _, layer := range image.Layers {
+			if verified[layer.Path] {
+				continue
+			}
+			verified[layer.Path] = true
+			h, err := r.hashLayerFile(layer)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			if layer.Digest != "" && h.digest != layer.Digest {
+				problems = append(problems, fmt.Sprintf("layer %s has digest %s, expected %s", layer.Path, h.digest, layer.Digest))
+			}
+			if layer.Size >= 0 && h.size != layer.Size {
+				problems = append(problems, fmt.Sprintf("layer %s has %d bytes, expected %d", layer.Path, h.size, layer.Size))
+			}
+			if h.diffID != layer.DiffID {
+				problems = append(problems, fmt.Sprintf("layer %s has diff id %s, the config lists %s", layer.Path, h.diffID, layer.DiffID))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &VerifyError{Problems: problems}
+	}
+	return nil
+}
+
+func (r *Reader) verifyBlob(name, digest string) []string {
+	data, err := fs.ReadFile(r.fsys, name)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if actual := digestOf(data); actual != digest {
+		return []string{fmt.Sprintf("%s has digest %s, expected %s", name, actual, digest)}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+// Type names an init system
+type Type string
+
+const (
+	TypeSystemd Type = "systemd"
+	TypeOpenRC  Type = "openrc"
+	TypeSysV    Type = "sysv"
+)
+
+// Detect returns the init system running as PID 1. root is the host filesystem, usually "/". PID 1 is
+// identified by its name and the runtime directories systemd and OpenRC create. If that is not
+// conclusive, e.g. inside a container, the management tools found in PATH decide.
+func Detect(root string, exec executil.Executor) (Type, error) {
+
+	if exec == nil {
+		exec = executil.New()
+	}
+
+	if exists(filepath.Join(root, "run/systemd/system")) {
+		return TypeSystemd, nil
+	}
+	if exists(filepath.Join(root, "run/openrc")) {
+		return TypeOpenRC, nil
+	}
+
+	pid1 := pid1Name(root)
+	if pid1 == "busybox" {
+		pid1 = "init"
+	}
+	switch {
+	case pid1 == "systemd":
+		return TypeSystemd, nil
+	case pid1 == "openrc-init":
+		return TypeOpenRC, nil
+	case pid1 == "init" && exists(filepath.Join(root, "sbin/openrc")):
+		// busybox or sysvinit starting openrc from inittab, e.g. alpine
+		return TypeOpenRC, nil
+	case pid1 == "init" && exists(filepath.Join(root, "etc/init.d")):
+		return TypeSysV, nil
+	}
+
+	for _, tool := range []struct {
+		name string
+		typ  Type
+	}{{"systemctl", TypeSystemd}, {"rc-service", TypeOpenRC}, {"service", TypeSysV}} {
+		if _, err := exec.LookPath(tool.name); err == nil {
+			return tool.typ, nil
+		}
+	}
+	return "", fmt.Errorf("no supported init system detected")
+}
+
+// pid1Name returns the command name of PID 1, preferring the target of /proc/1/exe over comm, which
+// is "init" for symlinks like /sbin/init -> /lib/systemd/systemd
+func pid1Name(root string) string {
+	if exe, err := os.Readlink(filepath.Join(root, "proc/1/exe")); err == nil {
+		return filepath.Base(strings.TrimSuffix(exe, " (deleted)"))
+	}
+	comm, err := ioutil.ReadFile(filepath.Join(root, "proc/1/comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
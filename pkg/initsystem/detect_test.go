@@ -0,0 +1,81 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestDetect(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// dirs are created below root, files are created with their content
+		dirs  []string
+		files map[string]string
+		// exe is the target of proc/1/exe, the link is not created if it is empty
+		exe     string
+		paths   map[string]string
+		want    Type
+		wantErr bool
+	}{
+		{name: "systemd runtime directory", dirs: []string{"run/systemd/system"}, want: TypeSystemd},
+		{name: "openrc runtime directory", dirs: []string{"run/openrc"}, want: TypeOpenRC},
+		{name: "systemd by exe", exe: "/lib/systemd/systemd", files: map[string]string{"proc/1/comm": "init\n"}, want: TypeSystemd},
+		{name: "deleted exe after upgrade", exe: "/lib/systemd/systemd (deleted)", want: TypeSystemd},
+		{name: "openrc-init", files: map[string]string{"proc/1/comm": "openrc-init\n"}, want: TypeOpenRC},
+		{name: "busybox starting openrc", exe: "/bin/busybox", files: map[string]string{"sbin/openrc": ""}, want: TypeOpenRC},
+		{name: "sysvinit", files: map[string]string{"proc/1/comm": "init\n"}, dirs: []string{"etc/init.d"}, want: TypeSysV},
+		{
+			name:  "container falls back to the tools in PATH",
+			files: map[string]string{"proc/1/comm": "sleep\n"},
+			paths: map[string]string{"rc-service": "/sbin/rc-service", "service": "/sbin/service"},
+			want:  TypeOpenRC,
+		},
+		{name: "nothing", files: map[string]string{"proc/1/comm": "sleep\n"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, dir := range tt.dirs {
+				if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, content := range tt.files {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.exe != "" {
+				if err := os.MkdirAll(filepath.Join(root, "proc/1"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(tt.exe, filepath.Join(root, "proc/1/exe")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			fake := executil.NewFakeExecutor()
+			fake.Paths = tt.paths
+			if fake.Paths == nil {
+				fake.Paths = map[string]string{}
+			}
+			got, err := Detect(root, fake)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Detect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Detect() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package initsystem
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeJournal is an in memory JournalSource for tests, entries are kept in the order they were added
+type FakeJournal struct {
+	mu      sync.Mutex
+	entries []JournalEntry
+	// Err is returned by Entries if set
+	Err error
+}
+
+// NewFakeJournal returns an empty FakeJournal
+func NewFakeJournal() *FakeJournal {
+	return &FakeJournal{entries: make([]JournalEntry, 0)}
+}
+
+// Add appends an entry, assigning a cursor and timestamp if they are empty
+func (f *FakeJournal) Add(entries ...JournalEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range entries {
+		if e.Cursor == "" {
+			e.Cursor = "fake-" + strconv.Itoa(len(f.entries)+1)
+		}
+		if e.Timestamp.IsZero() {
+			e.Timestamp = time.Now()
+		}
+		f.entries = append(f.entries, e)
+	}
+}
+
+// Log appends a message of unit with PriorityInfo
+func (f *FakeJournal) Log(unit, message string) {
+	f.Add(JournalEntry{Unit: unitName(unit), Identifier: unit, Priority: PriorityInfo, Message: message})
+}
+
+func (f *FakeJournal) Entries(ctx context.Context, q JournalQuery) ([]JournalEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	start := 0
+	if q.Cursor != "" {
+		for i, e := range f.entries {
+			if e.Cursor == q.Cursor {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	matched := make([]JournalEntry, 0)
+	for _, e := range f.entries[start:] {
+		if q.Unit != "" && e.Unit != unitName(q.Unit) {
+			continue
+		}
+		if q.Priority != nil && e.Priority > *q.Priority {
+			continue
+		}
+		if !q.Since.IsZero() && e.Timestamp.Before(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && e.Timestamp.After(q.Until) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	if q.Lines > 0 && len(matched) > q.Lines {
+		matched = matched[len(matched)-q.Lines:]
+	}
+	return matched, nil
+}
@@ -44,4 +44,7 @@ type InitSystem interface {
 
 	// ServiceIsActive ensures the service is running, or attempting to run. (crash looping in the case of kubelet)
 	ServiceIsInActive(service string) bool
+
+	// ServiceStatus returns the load and activation state, main process and accounting of the service
+	ServiceStatus(service string) (*ServiceStatus, error)
 }
@@ -20,14 +20,14 @@ limitations under the License.
 package initsystem
 
 import (
+	"context"
 	"fmt"
 	"k8s.io/klog/v2"
-	"os/exec"
 	"strings"
 	"time"
 
-	"github.com/cenkalti/backoff/v4"
-	"github.com/pkg/errors"
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+	godbus "github.com/godbus/dbus/v5"
 )
 
 const (
@@ -36,23 +36,41 @@ const (
 )
 
 // SystemdInitSystem defines systemd
-type SystemdInitSystem struct{}
+type SystemdInitSystem struct {
+	// Exec runs systemctl, nil runs it on the host
+	Exec executil.Executor
+}
+
+// NewSystemdInitSystem returns a SystemdInitSystem running systemctl with exec
+func NewSystemdInitSystem(exec executil.Executor) *SystemdInitSystem {
+	return &SystemdInitSystem{Exec: exec}
+}
+
+func (sysd SystemdInitSystem) systemctl(args ...string) ([]byte, error) {
+	exec := sysd.Exec
+	if exec == nil {
+		exec = executil.New()
+	}
+	return exec.Run(nil, "systemctl", args...)
+}
 
 // EnableCommand return a string describing how to enable a service
 func (sysd SystemdInitSystem) ServiceEnable(service string) error {
 	args := []string{"enable", service}
-	return exec.Command("systemctl", args...).Run()
+	_, err := sysd.systemctl(args...)
+	return err
 }
 
 // DisableCommand return a string describing how to enable a service
 func (sysd SystemdInitSystem) ServiceDisable(service string) error {
 	args := []string{"disable", service}
-	return exec.Command("systemctl", args...).Run()
+	_, err := sysd.systemctl(args...)
+	return err
 }
 
 // reloadSystemd reloads the systemd daemon
 func (sysd SystemdInitSystem) reloadSystemd() error {
-	if err := exec.Command("systemctl", "daemon-reload").Run(); err != nil {
+	if _, err := sysd.systemctl("daemon-reload"); err != nil {
 		return fmt.Errorf("failed to reload systemd: %v", err)
 	}
 	return nil
@@ -65,7 +83,8 @@ func (sysd SystemdInitSystem) ServiceStart(service string) error {
 		return err
 	}
 	args := []string{"start", service}
-	return exec.Command("systemctl", args...).Run()
+	_, err := sysd.systemctl(args...)
+	return err
 }
 
 // ServiceRestart tries to reload the environment and restart the specific service
@@ -75,67 +94,151 @@ func (sysd SystemdInitSystem) ServiceRestart(service string) error {
 		return err
 	}
 	args := []string{"restart", service}
-	return exec.Command("systemctl", args...).Run()
+	_, err := sysd.systemctl(args...)
+	return err
 }
 
 // ServiceStop tries to stop a specific service
 func (sysd SystemdInitSystem) ServiceStop(service string) error {
 	args := []string{"stop", service}
-	return exec.Command("systemctl", args...).Run()
+	_, err := sysd.systemctl(args...)
+	return err
 }
 
 // ServiceExists ensures the service is defined for this init system.
 func (sysd SystemdInitSystem) ServiceExists(service string) bool {
-	args := []string{"status", service}
-	outBytes, _ := exec.Command("systemctl", args...).Output()
-	output := string(outBytes)
-	return !strings.Contains(output, "Loaded: not-found")
+	status, err := sysd.ServiceStatus(service)
+	return err == nil && status.Exists()
 }
 
-// ServiceIsEnabled ensures the service is enabled to start on each boot.
+// ServiceIsEnabled ensures the service is enabled to start on each boot. The unit file state of the
+// status is classified by ServiceStatus.IsEnabled.
 func (sysd SystemdInitSystem) ServiceIsEnabled(service string) bool {
-	args := []string{"is-enabled", service}
-	err := exec.Command("systemctl", args...).Run()
-	return err == nil
+	status, err := sysd.ServiceStatus(service)
+	return err == nil && status.IsEnabled()
 }
 
 // ServiceIsActive will check is the service is "active".
 func (sysd SystemdInitSystem) ServiceIsActive(service string) bool {
-	output := sysd.serviceStatus(service)
-
-	return output == "active"
+	status, err := sysd.ServiceStatus(service)
+	return err == nil && status.IsActive()
 }
 
 // ServiceIsInActive will check is the service is "inactive".
 func (sysd SystemdInitSystem) ServiceIsInActive(service string) bool {
+	status, err := sysd.ServiceStatus(service)
+	// an unknown state counts as inactive, like `systemctl is-active` printing "unknown"
+	return err != nil || status.IsInactive()
+}
+
+// ServiceStatus parses the properties printed by `systemctl show`
+func (sysd SystemdInitSystem) ServiceStatus(service string) (*ServiceStatus, error) {
+	args := []string{"show", "--property=" + strings.Join(statusProperties, ","), service}
+	out, err := sysd.systemctl(args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to show %s: %v", service, err)
+	}
+	status, err := parseShowOutput(string(out))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse status of %s: %v", service, err)
+	}
+	return status, nil
+}
+
+// InitSystemOption configures the InitSystem returned by GetInitSystem
+type InitSystemOption func(o *initSystemOptions)
+
+type initSystemOptions struct {
+	typ        Type
+	root       string
+	exec       executil.Executor
+	dbus       bool
+	dial       func() (*godbus.Conn, error)
+	jobTimeout time.Duration
+}
 
-	output := sysd.serviceStatus(service)
-	if output == "inactive" || output == "unknown" || output == "failed" {
-		return true
+// WithType skips detection and returns the backend for typ
+func WithType(typ Type) InitSystemOption {
+	return func(o *initSystemOptions) {
+		o.typ = typ
 	}
-	return false
 }
 
-func (sysd SystemdInitSystem) serviceStatus(service string) string {
-	args := []string{"is-active", service}
-	// Ignoring error here, command returns non-0 if in "activating" status:
-	outBytes, _ := exec.Command("systemctl", args...).Output()
-	return strings.TrimSpace(string(outBytes))
+// WithRoot detects the init system of the filesystem mounted at root instead of "/"
+func WithRoot(root string) InitSystemOption {
+	return func(o *initSystemOptions) {
+		o.root = root
+	}
+}
+
+// WithExecutor runs the commands of the command line backends with exec, e.g. a FakeExecutor in tests
+func WithExecutor(exec executil.Executor) InitSystemOption {
+	return func(o *initSystemOptions) {
+		o.exec = exec
+	}
+}
+
+// WithDBus selects the D-Bus systemd backend instead of forking systemctl
+func WithDBus() InitSystemOption {
+	return func(o *initSystemOptions) {
+		o.dbus = true
+	}
+}
+
+// WithDBusConnection selects the D-Bus systemd backend connected through dial, e.g. to a private bus
+func WithDBusConnection(dial func() (*godbus.Conn, error)) InitSystemOption {
+	return func(o *initSystemOptions) {
+		o.dbus = true
+		o.dial = dial
+	}
+}
+
+// WithJobTimeout bounds how long the D-Bus backend waits for a job to finish
+func WithJobTimeout(timeout time.Duration) InitSystemOption {
+	return func(o *initSystemOptions) {
+		o.jobTimeout = timeout
+	}
 }
 
 // GetInitSystem returns an InitSystem for the current system, or nil
 // if we cannot detect a supported init system.
 // This indicates we will skip init system checks, not an error.
-func GetInitSystem() (InitSystem, error) {
-	// Assume existence of systemctl in path implies this is a systemd system:
-	_, err := exec.LookPath("systemctl")
-	if err == nil {
-		return &SystemdInitSystem{}, nil
+func GetInitSystem(opts ...InitSystemOption) (InitSystem, error) {
+	o := &initSystemOptions{root: "/"}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.dbus {
+		// return a nil interface on error, not a typed nil *DBusSystemdInitSystem
+		sysd, err := NewDBusSystemdInitSystem(o.dial, o.jobTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return sysd, nil
 	}
 
-	return nil, fmt.Errorf("no supported init system detected, skipping checking for services")
+	typ := o.typ
+	if typ == "" {
+		detected, err := Detect(o.root, o.exec)
+		if err != nil {
+			return nil, fmt.Errorf("no supported init system detected, skipping checking for services")
+		}
+		typ = detected
+	}
+
+	switch typ {
+	case TypeSystemd:
+		return NewSystemdInitSystem(o.exec), nil
+	case TypeOpenRC:
+		return NewOpenRCInitSystem(o.exec), nil
+	case TypeSysV:
+		return &SysVInitSystem{Exec: o.exec, Root: o.root}, nil
+	}
+	return nil, fmt.Errorf("unsupported init system %q", typ)
 }
 
+// EnsureStopService stops and disables the service, then waits up to DefaultStopAttempts intervals
+// until it is inactive
 func EnsureStopService(service string) error {
 
 	klog.Infof("stop service %s", service)
@@ -156,15 +259,8 @@ func EnsureStopService(service string) error {
 		return err
 	}
 
-	f := func() error {
-		if !systemctl.ServiceIsInActive(service) {
-			return errors.New(fmt.Sprintf("error stop service<%s>", service))
-		}
-		return nil
-	}
-
-	if err := backoff.Retry(f, backoff.WithMaxRetries(backoff.NewConstantBackOff(DefaultStopIntervals), DefaultStopAttempts)); err != nil {
-		return err
-	}
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStopIntervals*DefaultStopAttempts)
+	defer cancel()
+	_, err = WaitInactive(ctx, systemctl, service, WaitOptions{Interval: DefaultStopIntervals})
+	return err
 }
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestSystemdServiceIsEnabled(t *testing.T) {
+
+	tests := []struct {
+		unitFileState string
+		want          bool
+	}{
+		{unitFileState: "enabled", want: true},
+		{unitFileState: "enabled-runtime", want: true},
+		{unitFileState: "static", want: true},
+		{unitFileState: "alias", want: true},
+		{unitFileState: "disabled", want: false},
+		{unitFileState: "masked", want: false},
+		{unitFileState: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unitFileState, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			fake.SetResult("systemctl show", "Id=kubelet.service\nLoadState=loaded\nActiveState=active\nUnitFileState="+tt.unitFileState+"\n", nil)
+			if got := NewSystemdInitSystem(fake).ServiceIsEnabled("kubelet"); got != tt.want {
+				t.Errorf("ServiceIsEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
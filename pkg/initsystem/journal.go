@@ -0,0 +1,237 @@
+package initsystem
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+// Priority is the syslog severity of a journal entry, lower is more severe
+type Priority int
+
+const (
+	PriorityEmerg Priority = iota
+	PriorityAlert
+	PriorityCrit
+	PriorityErr
+	PriorityWarning
+	PriorityNotice
+	PriorityInfo
+	PriorityDebug
+)
+
+const DefaultFollowInterval = 1 * time.Second
+
+var priorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+func (p Priority) String() string {
+	if p < PriorityEmerg || p > PriorityDebug {
+		return strconv.Itoa(int(p))
+	}
+	return priorityNames[p]
+}
+
+// JournalEntry is a decoded journal record
+type JournalEntry struct {
+	Cursor     string    `json:"cursor"`
+	Timestamp  time.Time `json:"timestamp"`
+	Unit       string    `json:"unit,omitempty"`
+	Identifier string    `json:"identifier,omitempty"`
+	PID        int       `json:"pid,omitempty"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Priority   Priority  `json:"priority"`
+	Message    string    `json:"message"`
+	// Fields holds every field of the record, including the ones above under their journal names
+	Fields map[string]string `json:"-"`
+}
+
+// String formats the entry like `journalctl -o short-iso`
+func (e JournalEntry) String() string {
+	ident := e.Identifier
+	if e.PID > 0 {
+		ident = fmt.Sprintf("%s[%d]", ident, e.PID)
+	}
+	return fmt.Sprintf("%s %s %s: %s", e.Timestamp.Format("2006-01-02T15:04:05-0700"), e.Hostname, ident, e.Message)
+}
+
+// JournalQuery selects journal entries, zero values do not filter
+type JournalQuery struct {
+	Unit string
+	// Lines keeps only the last Lines matching entries
+	Lines int
+	// Priority keeps entries at least as severe as *Priority
+	Priority *Priority
+	Since    time.Time
+	Until    time.Time
+	// Cursor keeps entries after the entry with this cursor
+	Cursor string
+}
+
+// JournalSource reads journal entries, oldest first
+type JournalSource interface {
+	Entries(ctx context.Context, q JournalQuery) ([]JournalEntry, error)
+}
+
+type journalctl struct {
+	exec executil.Executor
+}
+
+// NewJournalctl returns a JournalSource running journalctl with exec, nil runs it on the host
+func NewJournalctl(exec executil.Executor) JournalSource {
+	if exec == nil {
+		exec = executil.New()
+	}
+	return &journalctl{exec: exec}
+}
+
+func (j *journalctl) Entries(ctx context.Context, q JournalQuery) ([]JournalEntry, error) {
+
+	args := []string{"--output", "json", "--no-pager", "--quiet"}
+	if q.Unit != "" {
+		args = append(args, "--unit", unitName(q.Unit))
+	}
+	if q.Lines > 0 {
+		args = append(args, "--lines", strconv.Itoa(q.Lines))
+	}
+	if q.Priority != nil {
+		args = append(args, "--priority", strconv.Itoa(int(*q.Priority)))
+	}
+	if !q.Since.IsZero() {
+		args = append(args, "--since", fmt.Sprintf("@%d", q.Since.Unix()))
+	}
+	if !q.Until.IsZero() {
+		args = append(args, "--until", fmt.Sprintf("@%d", q.Until.Unix()))
+	}
+	if q.Cursor != "" {
+		args = append(args, "--after-cursor", q.Cursor)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	out, err := j.exec.Run(nil, "journalctl", args...)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeJournalJSON(out)
+}
+
+// DecodeJournalJSON decodes the output of `journalctl -o json`, one record per line
+func DecodeJournalJSON(data []byte) ([]JournalEntry, error) {
+
+	entries := make([]JournalEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	// records carry whole log lines, which may be much longer than the default token size
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entry, err := decodeJournalRecord(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, scanner.Err()
+}
+
+func decodeJournalRecord(line []byte) (*JournalEntry, error) {
+
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, fmt.Errorf("invalid journal record: %v", err)
+	}
+
+	fields := make(map[string]string, len(raw))
+	for name, value := range raw {
+		fields[name] = journalFieldValue(value)
+	}
+
+	entry := &JournalEntry{
+		Cursor:     fields["__CURSOR"],
+		Unit:       fields["_SYSTEMD_UNIT"],
+		Identifier: fields["SYSLOG_IDENTIFIER"],
+		Hostname:   fields["_HOSTNAME"],
+		Message:    fields["MESSAGE"],
+		Priority:   PriorityInfo,
+		Fields:     fields,
+	}
+	if usec, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		entry.Timestamp = time.Unix(0, usec*int64(time.Microsecond))
+	}
+	if pid, err := strconv.Atoi(fields["_PID"]); err == nil {
+		entry.PID = pid
+	}
+	if p, err := strconv.Atoi(fields["PRIORITY"]); err == nil {
+		entry.Priority = Priority(p)
+	}
+	return entry, nil
+}
+
+// journalFieldValue converts a json field value, journalctl prints binary values as byte arrays and
+// fields set several times as arrays, of which the last value is kept
+func journalFieldValue(value json.RawMessage) string {
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	var b []byte
+	var nums []int
+	if err := json.Unmarshal(value, &nums); err == nil {
+		for _, n := range nums {
+			b = append(b, byte(n))
+		}
+		return string(b)
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(value, &values); err == nil && len(values) > 0 {
+		return journalFieldValue(values[len(values)-1])
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// FollowJournal polls source every interval for entries matching q and passes new ones to fn, until
+// ctx is done or fn fails. Polling resumes after the cursor of the last entry seen, which is returned
+// so a later call can continue where this one stopped.
+func FollowJournal(ctx context.Context, source JournalSource, q JournalQuery, interval time.Duration, fn func(JournalEntry) error) (string, error) {
+
+	if interval <= 0 {
+		interval = DefaultFollowInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := source.Entries(ctx, q)
+		if err != nil && ctx.Err() == nil {
+			return q.Cursor, err
+		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return q.Cursor, err
+			}
+			q.Cursor = entry.Cursor
+		}
+		if len(entries) > 0 || q.Cursor != "" {
+			// the initial window only applies to the first poll
+			q.Lines = 0
+			q.Since = time.Time{}
+		}
+
+		select {
+		case <-ctx.Done():
+			return q.Cursor, nil
+		case <-ticker.C:
+		}
+	}
+}
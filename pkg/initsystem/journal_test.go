@@ -0,0 +1,155 @@
+package initsystem
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestJournalctlArgs(t *testing.T) {
+
+	warning := PriorityWarning
+	since := time.Unix(1672671845, 0)
+	tests := []struct {
+		name  string
+		query JournalQuery
+		want  string
+	}{
+		{name: "all", want: "journalctl --output json --no-pager --quiet"},
+		{name: "unit", query: JournalQuery{Unit: "kubelet", Lines: 20}, want: "journalctl --output json --no-pager --quiet --unit kubelet.service --lines 20"},
+		{name: "timer unit", query: JournalQuery{Unit: "backup.timer"}, want: "journalctl --output json --no-pager --quiet --unit backup.timer"},
+		{
+			name:  "filters",
+			query: JournalQuery{Priority: &warning, Since: since, Until: since.Add(time.Hour), Cursor: "s=1;i=2"},
+			want:  "journalctl --output json --no-pager --quiet --priority 4 --since @1672671845 --until @1672675445 --after-cursor s=1;i=2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			if _, err := NewJournalctl(fake).Entries(context.Background(), tt.query); err != nil {
+				t.Fatal(err)
+			}
+			if got := fake.CommandLines(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("got %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJournalJSON(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []JournalEntry
+		wantErr bool
+	}{
+		{name: "empty", data: "\n", want: []JournalEntry{}},
+		{
+			name: "record",
+			data: `{"__CURSOR":"c1","__REALTIME_TIMESTAMP":"1672671845000000","_SYSTEMD_UNIT":"kubelet.service","SYSLOG_IDENTIFIER":"kubelet","_PID":"42","_HOSTNAME":"node1","PRIORITY":"3","MESSAGE":"failed"}`,
+			want: []JournalEntry{{
+				Cursor: "c1", Timestamp: time.Unix(1672671845, 0), Unit: "kubelet.service", Identifier: "kubelet",
+				PID: 42, Hostname: "node1", Priority: PriorityErr, Message: "failed",
+			}},
+		},
+		{
+			// binary messages are byte arrays, repeated fields are arrays of which the last value is kept
+			name: "binary and repeated fields",
+			data: `{"__CURSOR":"c2","MESSAGE":[104,105],"SYSLOG_IDENTIFIER":["a","b"]}`,
+			want: []JournalEntry{{Cursor: "c2", Identifier: "b", Priority: PriorityInfo, Message: "hi"}},
+		},
+		{name: "invalid", data: `{"MESSAGE":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeJournalJSON([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeJournalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i := range got {
+				got[i].Fields = nil
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeJournalFilters(t *testing.T) {
+
+	base := time.Unix(1672671845, 0)
+	journal := NewFakeJournal()
+	journal.Add(
+		JournalEntry{Unit: "kubelet.service", Priority: PriorityInfo, Message: "starting", Timestamp: base},
+		JournalEntry{Unit: "containerd.service", Priority: PriorityErr, Message: "oops", Timestamp: base.Add(time.Second)},
+		JournalEntry{Unit: "kubelet.service", Priority: PriorityErr, Message: "failed", Timestamp: base.Add(2 * time.Second)},
+		JournalEntry{Unit: "kubelet.service", Priority: PriorityDebug, Message: "retry", Timestamp: base.Add(3 * time.Second)},
+	)
+
+	errPriority := PriorityErr
+	tests := []struct {
+		name  string
+		query JournalQuery
+		want  []string
+	}{
+		{name: "all", want: []string{"starting", "oops", "failed", "retry"}},
+		{name: "unit", query: JournalQuery{Unit: "kubelet"}, want: []string{"starting", "failed", "retry"}},
+		{name: "last lines", query: JournalQuery{Unit: "kubelet", Lines: 2}, want: []string{"failed", "retry"}},
+		{name: "priority", query: JournalQuery{Priority: &errPriority}, want: []string{"oops", "failed"}},
+		{name: "since", query: JournalQuery{Since: base.Add(2 * time.Second)}, want: []string{"failed", "retry"}},
+		{name: "until", query: JournalQuery{Until: base.Add(time.Second)}, want: []string{"starting", "oops"}},
+		{name: "after cursor", query: JournalQuery{Cursor: "fake-2"}, want: []string{"failed", "retry"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := journal.Entries(context.Background(), tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(entries))
+			for _, e := range entries {
+				got = append(got, e.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowJournal(t *testing.T) {
+
+	journal := NewFakeJournal()
+	journal.Log("kubelet", "old")
+	journal.Log("kubelet", "first")
+
+	stop := errors.New("stop")
+	got := make([]string, 0)
+	cursor, err := FollowJournal(context.Background(), journal, JournalQuery{Unit: "kubelet", Lines: 1}, time.Millisecond, func(e JournalEntry) error {
+		got = append(got, e.Message)
+		if len(got) == 1 {
+			// entries added while following are picked up by the next poll
+			journal.Log("containerd", "other")
+			journal.Log("kubelet", "second")
+			return nil
+		}
+		return stop
+	})
+	if err != stop {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// the entry fn failed for is not consumed
+	if cursor != "fake-2" {
+		t.Errorf("got cursor %s, want fake-2", cursor)
+	}
+}
@@ -0,0 +1,161 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+const DefaultRunlevel = "default"
+
+// rc-service status exit codes
+const (
+	openrcStatusStarted = 0
+	openrcStatusStopped = 3
+)
+
+// OpenRCInitSystem manages services with rc-service and rc-update
+type OpenRCInitSystem struct {
+	// Exec runs the OpenRC tools, nil runs them on the host
+	Exec executil.Executor
+	// Runlevel services are enabled in, DefaultRunlevel if empty
+	Runlevel string
+}
+
+// NewOpenRCInitSystem returns an OpenRCInitSystem enabling services in DefaultRunlevel
+func NewOpenRCInitSystem(exec executil.Executor) *OpenRCInitSystem {
+	return &OpenRCInitSystem{Exec: exec, Runlevel: DefaultRunlevel}
+}
+
+func (rc OpenRCInitSystem) run(name string, args ...string) ([]byte, error) {
+	exec := rc.Exec
+	if exec == nil {
+		exec = executil.New()
+	}
+	return exec.Run(nil, name, args...)
+}
+
+func (rc OpenRCInitSystem) runlevel() string {
+	if rc.Runlevel == "" {
+		return DefaultRunlevel
+	}
+	return rc.Runlevel
+}
+
+// ServiceEnable adds the service to the runlevel
+func (rc OpenRCInitSystem) ServiceEnable(service string) error {
+	_, err := rc.run("rc-update", "add", service, rc.runlevel())
+	return err
+}
+
+// ServiceDisable removes the service from the runlevel
+func (rc OpenRCInitSystem) ServiceDisable(service string) error {
+	_, err := rc.run("rc-update", "del", service, rc.runlevel())
+	return err
+}
+
+// ServiceStart tries to start a specific service
+func (rc OpenRCInitSystem) ServiceStart(service string) error {
+	_, err := rc.run("rc-service", service, "start")
+	return err
+}
+
+// ServiceStop tries to stop a specific service
+func (rc OpenRCInitSystem) ServiceStop(service string) error {
+	_, err := rc.run("rc-service", service, "stop")
+	return err
+}
+
+// ServiceRestart tries to restart the specific service
+func (rc OpenRCInitSystem) ServiceRestart(service string) error {
+	_, err := rc.run("rc-service", service, "restart")
+	return err
+}
+
+// ServiceExists ensures the service is defined for this init system.
+func (rc OpenRCInitSystem) ServiceExists(service string) bool {
+	_, err := rc.run("rc-service", "--exists", service)
+	return err == nil
+}
+
+// ServiceIsEnabled ensures the service is added to the runlevel.
+func (rc OpenRCInitSystem) ServiceIsEnabled(service string) bool {
+	out, err := rc.run("rc-update", "show", rc.runlevel())
+	if err != nil {
+		return false
+	}
+	// lines look like "  sshd | default"
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(line, "|", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == service && strings.TrimSpace(parts[1]) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceIsActive will check is the service is "active".
+func (rc OpenRCInitSystem) ServiceIsActive(service string) bool {
+	status, err := rc.ServiceStatus(service)
+	return err == nil && status.IsActive()
+}
+
+// ServiceIsInActive will check is the service is "inactive".
+func (rc OpenRCInitSystem) ServiceIsInActive(service string) bool {
+	status, err := rc.ServiceStatus(service)
+	return err != nil || status.IsInactive()
+}
+
+// ServiceStatus maps the OpenRC state of the service, e.g. "started" or "crashed", onto the systemd
+// states. Process and accounting fields are not known to OpenRC and stay empty.
+func (rc OpenRCInitSystem) ServiceStatus(service string) (*ServiceStatus, error) {
+
+	status := &ServiceStatus{Name: service, LoadState: "not-found", ActiveState: "inactive", SubState: "dead"}
+	if !rc.ServiceExists(service) {
+		return status, nil
+	}
+	status.LoadState = "loaded"
+	if rc.ServiceIsEnabled(service) {
+		status.UnitFileState = "enabled"
+	} else {
+		status.UnitFileState = "disabled"
+	}
+
+	// " * status: started", rc-service exits with 3 if the service is stopped
+	out, err := rc.run("rc-service", service, "status")
+	code := 0
+	if err != nil {
+		if code = executil.ExitCode(err); code < 0 {
+			return nil, fmt.Errorf("failed to get status of %s: %v", service, err)
+		}
+	}
+	// openrc prints the state of stopped services to stderr
+	text := string(out)
+	if exitErr, ok := err.(*executil.ExitError); ok {
+		text += "\n" + exitErr.Stderr
+	}
+	state := ""
+	if i := strings.LastIndex(text, "status:"); i >= 0 {
+		state = strings.TrimSpace(strings.SplitN(text[i+len("status:"):], "\n", 2)[0])
+	}
+
+	switch {
+	case state == "started" || state == "" && code == openrcStatusStarted:
+		status.ActiveState, status.SubState = "active", "running"
+	case state == "starting":
+		status.ActiveState, status.SubState = "activating", "start"
+	case state == "stopping":
+		status.ActiveState, status.SubState = "deactivating", "stop"
+	case state == "crashed":
+		status.ActiveState, status.SubState, status.Result = "failed", "failed", "exit-code"
+	case state == "stopped" || code == openrcStatusStopped:
+		status.ActiveState, status.SubState = "inactive", "dead"
+	default:
+		status.ActiveState, status.SubState = "inactive", state
+	}
+	return status, nil
+}
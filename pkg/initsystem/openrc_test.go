@@ -0,0 +1,95 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestOpenRCServiceStatus(t *testing.T) {
+
+	runlevel := "  sshd | default\n  chronyd | default\n  kubelet |\n"
+	tests := []struct {
+		name    string
+		service string
+		// script prepares the results of rc-service and rc-update
+		script  func(f *executil.FakeExecutor)
+		want    ServiceStatus
+		wantErr bool
+	}{
+		{
+			name:    "started",
+			service: "sshd",
+			script: func(f *executil.FakeExecutor) {
+				f.SetResult("rc-service sshd status", " * status: started\n", nil)
+			},
+			want: ServiceStatus{Name: "sshd", LoadState: "loaded", ActiveState: "active", SubState: "running", UnitFileState: "enabled"},
+		},
+		{
+			name:    "stopped on stderr",
+			service: "chronyd",
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("rc-service chronyd status", 3, " * status: stopped")
+			},
+			want: ServiceStatus{Name: "chronyd", LoadState: "loaded", ActiveState: "inactive", SubState: "dead", UnitFileState: "enabled"},
+		},
+		{
+			name:    "stopped without output",
+			service: "chronyd",
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("rc-service chronyd status", 3, "")
+			},
+			want: ServiceStatus{Name: "chronyd", LoadState: "loaded", ActiveState: "inactive", SubState: "dead", UnitFileState: "enabled"},
+		},
+		{
+			name:    "crashed and not in the runlevel",
+			service: "kubelet",
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("rc-service kubelet status", 32, " * status: crashed")
+			},
+			want: ServiceStatus{Name: "kubelet", LoadState: "loaded", ActiveState: "failed", SubState: "failed", Result: "exit-code", UnitFileState: "disabled"},
+		},
+		{
+			name:    "starting",
+			service: "sshd",
+			script: func(f *executil.FakeExecutor) {
+				f.SetResult("rc-service sshd status", " * status: starting\n", nil)
+			},
+			want: ServiceStatus{Name: "sshd", LoadState: "loaded", ActiveState: "activating", SubState: "start", UnitFileState: "enabled"},
+		},
+		{
+			name:    "missing",
+			service: "docker",
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("rc-service --exists docker", 1, "")
+			},
+			want: ServiceStatus{Name: "docker", LoadState: "not-found", ActiveState: "inactive", SubState: "dead"},
+		},
+		{
+			name:    "rc-service not runnable",
+			service: "sshd",
+			script: func(f *executil.FakeExecutor) {
+				f.SetResult("rc-service sshd status", "", errors.New("exec: \"rc-service\": executable file not found in $PATH"))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			fake.SetResult("rc-update show default", runlevel, nil)
+			tt.script(fake)
+			status, err := NewOpenRCInitSystem(fake).ServiceStatus(tt.service)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ServiceStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *status != tt.want {
+				t.Errorf("got %+v, want %+v", *status, tt.want)
+			}
+		})
+	}
+}
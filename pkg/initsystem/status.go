@@ -0,0 +1,173 @@
+package initsystem
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// systemdTimestampLayout is how `systemctl show` prints timestamps, e.g. "Mon 2023-01-02 15:04:05 UTC"
+const systemdTimestampLayout = "Mon 2006-01-02 15:04:05 MST"
+
+// statusProperties are the unit properties ServiceStatus reads
+var statusProperties = []string{
+	"Id", "LoadState", "ActiveState", "SubState", "UnitFileState", "MainPID", "ExecMainStatus", "Result",
+	"NRestarts", "MemoryCurrent", "CPUUsageNSec", "ActiveEnterTimestamp", "ActiveExitTimestamp",
+	"InactiveEnterTimestamp", "StateChangeTimestamp", "ExecMainStartTimestamp",
+}
+
+// ServiceStatus is the state of a unit as reported by the init system
+type ServiceStatus struct {
+	Name string `json:"name"`
+	// LoadState is "loaded", "not-found", "masked" or "error"
+	LoadState string `json:"loadState"`
+	// ActiveState is "active", "reloading", "inactive", "failed", "activating" or "deactivating"
+	ActiveState string `json:"activeState"`
+	// SubState is type specific, e.g. "running", "exited" or "auto-restart" for services
+	SubState      string `json:"subState"`
+	UnitFileState string `json:"unitFileState,omitempty"`
+
+	// MainPID is 0 if the service has no running main process
+	MainPID int `json:"mainPID,omitempty"`
+	// ExecMainStatus is the exit code or signal of the last main process
+	ExecMainStatus int `json:"execMainStatus"`
+	// Result is why the service last stopped, e.g. "success", "exit-code" or "start-limit-hit"
+	Result string `json:"result,omitempty"`
+	// NRestarts counts the automatic restarts since the service was last started manually
+	NRestarts int `json:"nRestarts"`
+
+	// MemoryCurrent and CPUUsageNSec are 0 if accounting is disabled
+	MemoryCurrent uint64 `json:"memoryCurrent,omitempty"`
+	CPUUsageNSec  uint64 `json:"cpuUsageNSec,omitempty"`
+
+	ActiveEnterTimestamp   time.Time `json:"activeEnterTimestamp,omitempty"`
+	ActiveExitTimestamp    time.Time `json:"activeExitTimestamp,omitempty"`
+	InactiveEnterTimestamp time.Time `json:"inactiveEnterTimestamp,omitempty"`
+	StateChangeTimestamp   time.Time `json:"stateChangeTimestamp,omitempty"`
+	ExecMainStartTimestamp time.Time `json:"execMainStartTimestamp,omitempty"`
+}
+
+// Exists returns false if no unit file was found for the unit
+func (s *ServiceStatus) Exists() bool {
+	return s.LoadState != "" && s.LoadState != "not-found"
+}
+
+// IsActive returns true if the unit is "active"
+func (s *ServiceStatus) IsActive() bool {
+	return s.ActiveState == "active"
+}
+
+// IsInactive returns true if the unit is stopped, failed or unknown
+func (s *ServiceStatus) IsInactive() bool {
+	return s.ActiveState == "inactive" || s.ActiveState == "failed" || s.ActiveState == ""
+}
+
+// IsEnabled returns true for the unit file states `systemctl is-enabled` succeeds for
+func (s *ServiceStatus) IsEnabled() bool {
+	switch s.UnitFileState {
+	case "enabled", "enabled-runtime", "static", "alias", "indirect", "generated", "transient":
+		return true
+	}
+	return false
+}
+
+// parseShowOutput parses the "Key=Value" lines printed by `systemctl show`. A value which does not
+// parse, e.g. a timestamp in an unexpected locale, is left zero instead of failing the whole status.
+func parseShowOutput(out string) (*ServiceStatus, error) {
+
+	status := &ServiceStatus{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], strings.TrimSpace(kv[1])
+
+		var err error
+		switch key {
+		case "Id":
+			status.Name = value
+		case "LoadState":
+			status.LoadState = value
+		case "ActiveState":
+			status.ActiveState = value
+		case "SubState":
+			status.SubState = value
+		case "UnitFileState":
+			status.UnitFileState = value
+		case "Result":
+			status.Result = value
+		case "MainPID":
+			status.MainPID, err = parseShowInt(value)
+		case "ExecMainStatus":
+			status.ExecMainStatus, err = parseShowInt(value)
+		case "NRestarts":
+			status.NRestarts, err = parseShowInt(value)
+		case "MemoryCurrent":
+			status.MemoryCurrent, err = parseShowUint(value)
+		case "CPUUsageNSec":
+			status.CPUUsageNSec, err = parseShowUint(value)
+		case "ActiveEnterTimestamp":
+			status.ActiveEnterTimestamp, err = parseShowTimestamp(value)
+		case "ActiveExitTimestamp":
+			status.ActiveExitTimestamp, err = parseShowTimestamp(value)
+		case "InactiveEnterTimestamp":
+			status.InactiveEnterTimestamp, err = parseShowTimestamp(value)
+		case "StateChangeTimestamp":
+			status.StateChangeTimestamp, err = parseShowTimestamp(value)
+		case "ExecMainStartTimestamp":
+			status.ExecMainStartTimestamp, err = parseShowTimestamp(value)
+		}
+		if err != nil {
+			klog.V(4).Infof("ignore invalid %s %q of %s: %v", key, value, status.Name, err)
+		}
+	}
+	return status, scanner.Err()
+}
+
+func parseShowInt(value string) (int, error) {
+	if value == "" || value == "[not set]" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// parseShowUint parses accounting values, which are "[not set]" or the max uint64 if disabled
+func parseShowUint(value string) (uint64, error) {
+	if value == "" || value == "[not set]" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || v == math.MaxUint64 {
+		return 0, err
+	}
+	return v, nil
+}
+
+// parseShowTimestamp parses "Mon 2023-01-02 15:04:05 UTC", or "@1672671845" with --timestamp=unix
+func parseShowTimestamp(value string) (time.Time, error) {
+	if value == "" || value == "n/a" {
+		return time.Time{}, nil
+	}
+	if strings.HasPrefix(value, "@") {
+		sec, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(sec, 0), nil
+	}
+	return time.ParseInLocation(systemdTimestampLayout, value, time.Local)
+}
+
+// usecTimestamp converts the microseconds since the epoch systemd uses on D-Bus, 0 means never
+func usecTimestamp(usec uint64) time.Time {
+	if usec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(usec)*int64(time.Microsecond))
+}
@@ -0,0 +1,318 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	sdbus "github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+const (
+	// DefaultJobTimeout bounds how long a start, stop or restart job may take, systemd's default
+	// start timeout is 90s
+	DefaultJobTimeout = 2 * time.Minute
+
+	jobModeReplace = "replace"
+	jobResultDone  = "done"
+)
+
+// JobError is returned when a systemd job did not finish with "done", it carries the state the unit
+// was left in so callers can tell a failing ExecStart from a timeout or a missing dependency
+type JobError struct {
+	Unit string
+	Job  string
+	// Result is the job result, e.g. "failed", "timeout", "canceled", "dependency" or "skipped"
+	Result string
+	// ActiveState, SubState and UnitResult describe the unit after the job, UnitResult is the Result
+	// property of the service, e.g. "exit-code" or "start-limit-hit"
+	ActiveState string
+	SubState    string
+	UnitResult  string
+}
+
+func (e *JobError) Error() string {
+	msg := fmt.Sprintf("%s %s: job %s", e.Job, e.Unit, e.Result)
+	if e.ActiveState != "" {
+		msg += fmt.Sprintf(", unit is %s (%s)", e.ActiveState, e.SubState)
+	}
+	if e.UnitResult != "" && e.UnitResult != "success" {
+		msg += fmt.Sprintf(", result %s", e.UnitResult)
+	}
+	return msg
+}
+
+// DBusSystemdInitSystem manages units through the systemd D-Bus api (org.freedesktop.systemd1)
+// instead of forking systemctl. Jobs are waited for, and failures report why the unit failed.
+type DBusSystemdInitSystem struct {
+	dial       func() (*godbus.Conn, error)
+	jobTimeout time.Duration
+
+	mu   sync.Mutex
+	conn *sdbus.Conn
+}
+
+// NewDBusSystemdInitSystem connects to systemd. dial returns an authenticated connection on which
+// Hello succeeded, it is called once per underlying connection; nil uses the system bus. A
+// jobTimeout of 0 uses DefaultJobTimeout.
+func NewDBusSystemdInitSystem(dial func() (*godbus.Conn, error), jobTimeout time.Duration) (*DBusSystemdInitSystem, error) {
+	if jobTimeout <= 0 {
+		jobTimeout = DefaultJobTimeout
+	}
+	sysd := &DBusSystemdInitSystem{dial: dial, jobTimeout: jobTimeout}
+	if _, err := sysd.connection(); err != nil {
+		return nil, err
+	}
+	return sysd, nil
+}
+
+// Close closes the D-Bus connection, later calls reconnect
+func (sysd *DBusSystemdInitSystem) Close() {
+	sysd.mu.Lock()
+	defer sysd.mu.Unlock()
+	if sysd.conn != nil {
+		sysd.conn.Close()
+		sysd.conn = nil
+	}
+}
+
+// connection returns the current connection, reconnecting if the bus went away
+func (sysd *DBusSystemdInitSystem) connection() (*sdbus.Conn, error) {
+	sysd.mu.Lock()
+	defer sysd.mu.Unlock()
+
+	if sysd.conn != nil && sysd.conn.Connected() {
+		return sysd.conn, nil
+	}
+	if sysd.conn != nil {
+		sysd.conn.Close()
+		sysd.conn = nil
+	}
+
+	var (
+		conn *sdbus.Conn
+		err  error
+	)
+	if sysd.dial == nil {
+		conn, err = sdbus.NewWithContext(context.Background())
+	} else {
+		conn, err = sdbus.NewConnection(sysd.dial)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to systemd: %v", err)
+	}
+	sysd.conn = conn
+	return conn, nil
+}
+
+// ServiceEnable enables the unit and reloads systemd, like `systemctl enable`
+func (sysd *DBusSystemdInitSystem) ServiceEnable(service string) error {
+	conn, err := sysd.connection()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sysd.jobTimeout)
+	defer cancel()
+
+	unit := unitName(service)
+	if _, _, err := conn.EnableUnitFilesContext(ctx, []string{unit}, false, false); err != nil {
+		return fmt.Errorf("failed to enable %s: %v", unit, err)
+	}
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %v", err)
+	}
+	return nil
+}
+
+// ServiceDisable disables the unit and reloads systemd, like `systemctl disable`
+func (sysd *DBusSystemdInitSystem) ServiceDisable(service string) error {
+	conn, err := sysd.connection()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sysd.jobTimeout)
+	defer cancel()
+
+	unit := unitName(service)
+	if _, err := conn.DisableUnitFilesContext(ctx, []string{unit}, false); err != nil {
+		return fmt.Errorf("failed to disable %s: %v", unit, err)
+	}
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %v", err)
+	}
+	return nil
+}
+
+// ServiceStart starts the unit and waits for the start job
+func (sysd *DBusSystemdInitSystem) ServiceStart(service string) error {
+	return sysd.runJob("start", service, true, (*sdbus.Conn).StartUnitContext)
+}
+
+// ServiceRestart restarts the unit and waits for the restart job
+func (sysd *DBusSystemdInitSystem) ServiceRestart(service string) error {
+	return sysd.runJob("restart", service, true, (*sdbus.Conn).RestartUnitContext)
+}
+
+// ServiceStop stops the unit and waits for the stop job
+func (sysd *DBusSystemdInitSystem) ServiceStop(service string) error {
+	return sysd.runJob("stop", service, false, (*sdbus.Conn).StopUnitContext)
+}
+
+type jobFunc func(c *sdbus.Conn, ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+
+// runJob queues a job for the unit and waits until systemd reports its result. The daemon is only
+// reloaded first if reload is set and the unit file changed on disk.
+func (sysd *DBusSystemdInitSystem) runJob(job, service string, reload bool, start jobFunc) error {
+	conn, err := sysd.connection()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sysd.jobTimeout)
+	defer cancel()
+
+	unit := unitName(service)
+	if reload {
+		if err := reloadIfNeeded(ctx, conn, unit); err != nil {
+			return err
+		}
+	}
+
+	ch := make(chan string, 1)
+	if _, err := start(conn, ctx, unit, jobModeReplace, ch); err != nil {
+		return fmt.Errorf("failed to %s %s: %v", job, unit, err)
+	}
+
+	select {
+	case result := <-ch:
+		if result == jobResultDone {
+			return nil
+		}
+		return unitJobError(conn, unit, job, result)
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for %s job of %s after %s", job, unit, sysd.jobTimeout)
+	}
+}
+
+// reloadIfNeeded runs daemon-reload only if systemd reports the unit file changed since it was loaded
+func reloadIfNeeded(ctx context.Context, conn *sdbus.Conn, unit string) error {
+	prop, err := conn.GetUnitPropertyContext(ctx, unit, "NeedDaemonReload")
+	if err != nil {
+		return fmt.Errorf("failed to get properties of %s: %v", unit, err)
+	}
+	if need, _ := prop.Value.Value().(bool); !need {
+		return nil
+	}
+	if err := conn.ReloadContext(ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %v", err)
+	}
+	return nil
+}
+
+// unitJobError builds a JobError with the state the unit was left in
+func unitJobError(conn *sdbus.Conn, unit, job, result string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	jobErr := &JobError{Unit: unit, Job: job, Result: result}
+	if props, err := conn.GetUnitPropertiesContext(ctx, unit); err == nil {
+		jobErr.ActiveState, _ = props["ActiveState"].(string)
+		jobErr.SubState, _ = props["SubState"].(string)
+	}
+	if strings.HasSuffix(unit, ".service") {
+		if prop, err := conn.GetServicePropertyContext(ctx, unit, "Result"); err == nil {
+			jobErr.UnitResult, _ = prop.Value.Value().(string)
+		}
+	}
+	return jobErr
+}
+
+// ServiceExists ensures the service is defined for this init system.
+func (sysd *DBusSystemdInitSystem) ServiceExists(service string) bool {
+	status, err := sysd.ServiceStatus(service)
+	return err == nil && status.Exists()
+}
+
+// ServiceIsEnabled ensures the service is enabled to start on each boot.
+func (sysd *DBusSystemdInitSystem) ServiceIsEnabled(service string) bool {
+	status, err := sysd.ServiceStatus(service)
+	return err == nil && status.IsEnabled()
+}
+
+// ServiceIsActive will check is the service is "active".
+func (sysd *DBusSystemdInitSystem) ServiceIsActive(service string) bool {
+	status, err := sysd.ServiceStatus(service)
+	return err == nil && status.IsActive()
+}
+
+// ServiceIsInActive will check is the service is "inactive".
+func (sysd *DBusSystemdInitSystem) ServiceIsInActive(service string) bool {
+	status, err := sysd.ServiceStatus(service)
+	return err != nil || status.IsInactive()
+}
+
+// ServiceStatus reads the properties of the unit, and those of the service interface for services
+func (sysd *DBusSystemdInitSystem) ServiceStatus(service string) (*ServiceStatus, error) {
+	conn, err := sysd.connection()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sysd.jobTimeout)
+	defer cancel()
+
+	unit := unitName(service)
+	props, err := conn.GetUnitPropertiesContext(ctx, unit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get properties of %s: %v", unit, err)
+	}
+
+	status := &ServiceStatus{}
+	status.Name, _ = props["Id"].(string)
+	status.LoadState, _ = props["LoadState"].(string)
+	status.ActiveState, _ = props["ActiveState"].(string)
+	status.SubState, _ = props["SubState"].(string)
+	status.UnitFileState, _ = props["UnitFileState"].(string)
+	status.ActiveEnterTimestamp = usecTimestamp(uint64Property(props, "ActiveEnterTimestamp"))
+	status.ActiveExitTimestamp = usecTimestamp(uint64Property(props, "ActiveExitTimestamp"))
+	status.InactiveEnterTimestamp = usecTimestamp(uint64Property(props, "InactiveEnterTimestamp"))
+	status.StateChangeTimestamp = usecTimestamp(uint64Property(props, "StateChangeTimestamp"))
+
+	if !strings.HasSuffix(unit, ".service") || !status.Exists() {
+		return status, nil
+	}
+	props, err = conn.GetUnitTypePropertiesContext(ctx, unit, "Service")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service properties of %s: %v", unit, err)
+	}
+	status.Result, _ = props["Result"].(string)
+	status.MainPID = int(uint64Property(props, "MainPID"))
+	status.NRestarts = int(uint64Property(props, "NRestarts"))
+	if code, ok := props["ExecMainStatus"].(int32); ok {
+		status.ExecMainStatus = int(code)
+	}
+	if memory := uint64Property(props, "MemoryCurrent"); memory != math.MaxUint64 {
+		status.MemoryCurrent = memory
+	}
+	if cpu := uint64Property(props, "CPUUsageNSec"); cpu != math.MaxUint64 {
+		status.CPUUsageNSec = cpu
+	}
+	status.ExecMainStartTimestamp = usecTimestamp(uint64Property(props, "ExecMainStartTimestamp"))
+	return status, nil
+}
+
+// uint64Property returns an unsigned D-Bus property, 0 if it is missing
+func uint64Property(props map[string]interface{}, name string) uint64 {
+	switch v := props[name].(type) {
+	case uint64:
+		return v
+	case uint32:
+		return uint64(v)
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+//go:build linux
+// +build linux
+
+package initsystem
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdbus "github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startPrivateBus runs a dbus-daemon for the test and returns a dial function connecting to it
+func startPrivateBus(t *testing.T) func() (*godbus.Conn, error) {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(busConfig, filepath.Join(dir, "bus"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read bus address: %v", err)
+	}
+	address = strings.TrimSpace(address)
+
+	return func() (*godbus.Conn, error) {
+		conn, err := godbus.Dial(address)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.Auth(nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.Hello(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// fakeUnit is the state the fake systemd reports for a unit and the result of its jobs
+type fakeUnit struct {
+	activeState string
+	subState    string
+	result      string
+	mainPID     uint32
+	jobResult   string
+}
+
+// fakeSystemd implements the parts of org.freedesktop.systemd1.Manager the D-Bus backend uses
+type fakeSystemd struct {
+	conn  *godbus.Conn
+	units map[string]fakeUnit
+	jobs  uint32
+}
+
+func (f *fakeSystemd) job(name string) (godbus.ObjectPath, *godbus.Error) {
+	unit, ok := f.units[name]
+	if !ok {
+		return "", godbus.NewError("org.freedesktop.systemd1.NoSuchUnit", []interface{}{"Unit " + name + " not found."})
+	}
+	id := atomic.AddUint32(&f.jobs, 1)
+	job := godbus.ObjectPath(fmt.Sprintf("/org/freedesktop/systemd1/job/%d", id))
+	// the client registers the job before it handles signals, so the signal may precede the reply
+	f.conn.Emit("/org/freedesktop/systemd1", "org.freedesktop.systemd1.Manager.JobRemoved", id, job, name, unit.jobResult)
+	return job, nil
+}
+
+func (f *fakeSystemd) StartUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return f.job(name)
+}
+
+func (f *fakeSystemd) StopUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return f.job(name)
+}
+
+func (f *fakeSystemd) RestartUnit(name, mode string) (godbus.ObjectPath, *godbus.Error) {
+	return f.job(name)
+}
+
+// serveFakeSystemd owns org.freedesktop.systemd1 on the bus and exports the manager and units
+func serveFakeSystemd(t *testing.T, dial func() (*godbus.Conn, error), units map[string]fakeUnit) {
+	t.Helper()
+
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fake := &fakeSystemd{conn: conn, units: units}
+	if err := conn.Export(fake, "/org/freedesktop/systemd1", "org.freedesktop.systemd1.Manager"); err != nil {
+		t.Fatal(err)
+	}
+	for name, unit := range units {
+		props := prop.Map{
+			"org.freedesktop.systemd1.Unit": {
+				"Id":               {Value: name},
+				"LoadState":        {Value: "loaded"},
+				"ActiveState":      {Value: unit.activeState},
+				"SubState":         {Value: unit.subState},
+				"UnitFileState":    {Value: "enabled"},
+				"NeedDaemonReload": {Value: false},
+			},
+			"org.freedesktop.systemd1.Service": {
+				"Result":         {Value: unit.result},
+				"MainPID":        {Value: unit.mainPID},
+				"NRestarts":      {Value: uint32(0)},
+				"ExecMainStatus": {Value: int32(0)},
+			},
+		}
+		path := godbus.ObjectPath("/org/freedesktop/systemd1/unit/" + sdbus.PathBusEscape(name))
+		if _, err := prop.Export(conn, path, props); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reply, err := conn.RequestName("org.freedesktop.systemd1", godbus.NameFlagDoNotQueue)
+	if err != nil || reply != godbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name: %v %v", reply, err)
+	}
+}
+
+func TestDBusSystemdInitSystem(t *testing.T) {
+
+	dial := startPrivateBus(t)
+	serveFakeSystemd(t, dial, map[string]fakeUnit{
+		"ok.service":  {activeState: "active", subState: "running", result: "success", mainPID: 42, jobResult: "done"},
+		"bad.service": {activeState: "failed", subState: "failed", result: "exit-code", jobResult: "failed"},
+	})
+
+	is, err := GetInitSystem(WithDBusConnection(dial), WithJobTimeout(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sysd := is.(*DBusSystemdInitSystem)
+	defer sysd.Close()
+
+	tests := []struct {
+		name    string
+		run     func(service string) error
+		service string
+		// jobErr is the expected JobError, nil if the job succeeds
+		jobErr *JobError
+		errMsg string
+	}{
+		{name: "start", run: sysd.ServiceStart, service: "ok"},
+		{name: "restart", run: sysd.ServiceRestart, service: "ok.service"},
+		{name: "stop", run: sysd.ServiceStop, service: "ok"},
+		{
+			name: "start failing", run: sysd.ServiceStart, service: "bad",
+			jobErr: &JobError{Unit: "bad.service", Job: "start", Result: "failed", ActiveState: "failed", SubState: "failed", UnitResult: "exit-code"},
+		},
+		{name: "missing unit", run: sysd.ServiceStart, service: "missing", errMsg: "missing.service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run(tt.service)
+			switch {
+			case tt.jobErr != nil:
+				var jobErr *JobError
+				if !errors.As(err, &jobErr) {
+					t.Fatalf("expected a JobError, got %v", err)
+				}
+				if *jobErr != *tt.jobErr {
+					t.Errorf("got %+v, want %+v", *jobErr, *tt.jobErr)
+				}
+			case tt.errMsg != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("expected an error containing %q, got %v", tt.errMsg, err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	status, err := sysd.ServiceStatus("ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsActive() || status.SubState != "running" || status.MainPID != 42 || !status.IsEnabled() {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if !sysd.ServiceIsInActive("bad") {
+		t.Errorf("failed unit is reported active")
+	}
+}
+
+func TestGetInitSystemDialError(t *testing.T) {
+	dial := func() (*godbus.Conn, error) {
+		return nil, errors.New("no bus")
+	}
+	is, err := GetInitSystem(WithDBusConnection(dial))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// a typed nil pointer inside the interface would pass this check and panic on use
+	if is != nil {
+		t.Fatalf("expected a nil InitSystem, got %#v", is)
+	}
+}
@@ -0,0 +1,178 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+// LSB init script status exit codes
+const (
+	lsbStatusRunning        = 0
+	lsbStatusDeadPidFile    = 1
+	lsbStatusDeadLockFile   = 2
+	lsbStatusNotRunning     = 3
+	lsbStatusUnknownService = 4
+)
+
+// SysVInitSystem manages init scripts with service, and enables them with chkconfig on rhel like
+// systems or update-rc.d on debian like systems
+type SysVInitSystem struct {
+	// Exec runs the tools, nil runs them on the host
+	Exec executil.Executor
+	// Root is the host filesystem the init scripts and rc directories are found in, "/" if empty
+	Root string
+}
+
+// NewSysVInitSystem returns a SysVInitSystem for the host filesystem
+func NewSysVInitSystem(exec executil.Executor) *SysVInitSystem {
+	return &SysVInitSystem{Exec: exec, Root: "/"}
+}
+
+func (sysv SysVInitSystem) executor() executil.Executor {
+	if sysv.Exec == nil {
+		return executil.New()
+	}
+	return sysv.Exec
+}
+
+func (sysv SysVInitSystem) path(elem ...string) string {
+	root := sysv.Root
+	if root == "" {
+		root = "/"
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// hasChkconfig tells rhel like systems, which register scripts with chkconfig, from debian like ones
+func (sysv SysVInitSystem) hasChkconfig() bool {
+	_, err := sysv.executor().LookPath("chkconfig")
+	return err == nil
+}
+
+// ServiceEnable links the init script into the default runlevels
+func (sysv SysVInitSystem) ServiceEnable(service string) error {
+	if sysv.hasChkconfig() {
+		_, err := sysv.executor().Run(nil, "chkconfig", service, "on")
+		return err
+	}
+	if _, err := sysv.executor().Run(nil, "update-rc.d", service, "defaults"); err != nil {
+		return err
+	}
+	_, err := sysv.executor().Run(nil, "update-rc.d", service, "enable")
+	return err
+}
+
+// ServiceDisable keeps the init script from being started in any runlevel
+func (sysv SysVInitSystem) ServiceDisable(service string) error {
+	if sysv.hasChkconfig() {
+		_, err := sysv.executor().Run(nil, "chkconfig", service, "off")
+		return err
+	}
+	_, err := sysv.executor().Run(nil, "update-rc.d", service, "disable")
+	return err
+}
+
+// ServiceStart tries to start a specific service
+func (sysv SysVInitSystem) ServiceStart(service string) error {
+	_, err := sysv.executor().Run(nil, "service", service, "start")
+	return err
+}
+
+// ServiceStop tries to stop a specific service
+func (sysv SysVInitSystem) ServiceStop(service string) error {
+	_, err := sysv.executor().Run(nil, "service", service, "stop")
+	return err
+}
+
+// ServiceRestart tries to restart the specific service
+func (sysv SysVInitSystem) ServiceRestart(service string) error {
+	_, err := sysv.executor().Run(nil, "service", service, "restart")
+	return err
+}
+
+// ServiceExists ensures the init script of the service is installed.
+func (sysv SysVInitSystem) ServiceExists(service string) bool {
+	return exists(sysv.path("etc/init.d", service))
+}
+
+// ServiceIsEnabled ensures the service is started in one of the multi user runlevels 2 to 5.
+func (sysv SysVInitSystem) ServiceIsEnabled(service string) bool {
+	if sysv.hasChkconfig() {
+		// "kubelet  0:off 1:off 2:on 3:on 4:on 5:on 6:off"
+		out, err := sysv.executor().Run(nil, "chkconfig", "--list", service)
+		if err != nil {
+			return false
+		}
+		for _, field := range strings.Fields(string(out)) {
+			switch field {
+			case "2:on", "3:on", "4:on", "5:on":
+				return true
+			}
+		}
+		return false
+	}
+	for _, level := range []string{"2", "3", "4", "5"} {
+		links, _ := filepath.Glob(sysv.path("etc/rc"+level+".d", "S[0-9][0-9]"+service))
+		if len(links) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceIsActive will check is the service is "active".
+func (sysv SysVInitSystem) ServiceIsActive(service string) bool {
+	status, err := sysv.ServiceStatus(service)
+	return err == nil && status.IsActive()
+}
+
+// ServiceIsInActive will check is the service is "inactive".
+func (sysv SysVInitSystem) ServiceIsInActive(service string) bool {
+	status, err := sysv.ServiceStatus(service)
+	return err != nil || status.IsInactive()
+}
+
+// ServiceStatus maps the LSB exit code of `service <name> status` onto the systemd states. Process and
+// accounting fields are not known and stay empty.
+func (sysv SysVInitSystem) ServiceStatus(service string) (*ServiceStatus, error) {
+
+	status := &ServiceStatus{Name: service, LoadState: "not-found", ActiveState: "inactive", SubState: "dead"}
+	if !sysv.ServiceExists(service) {
+		return status, nil
+	}
+	status.LoadState = "loaded"
+	if sysv.ServiceIsEnabled(service) {
+		status.UnitFileState = "enabled"
+	} else {
+		status.UnitFileState = "disabled"
+	}
+
+	_, err := sysv.executor().Run(nil, "service", service, "status")
+	code := lsbStatusRunning
+	if err != nil {
+		if code = executil.ExitCode(err); code < 0 {
+			return nil, fmt.Errorf("failed to get status of %s: %v", service, err)
+		}
+	}
+
+	switch code {
+	case lsbStatusRunning:
+		status.ActiveState, status.SubState = "active", "running"
+	case lsbStatusDeadPidFile, lsbStatusDeadLockFile:
+		// the process is gone but left its pid or lock file behind, it crashed
+		status.ActiveState, status.SubState, status.Result = "failed", "failed", "exit-code"
+	case lsbStatusNotRunning:
+		status.ActiveState, status.SubState = "inactive", "dead"
+	case lsbStatusUnknownService:
+		status.LoadState = "not-found"
+	default:
+		status.ActiveState, status.SubState = "inactive", "unknown"
+	}
+	return status, nil
+}
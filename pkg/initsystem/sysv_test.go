@@ -0,0 +1,103 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestSysVServiceStatus(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// chkconfig is the output of chkconfig --list, debian like systems without chkconfig are
+		// checked through their rc directories if it is empty
+		chkconfig string
+		// statusErr is the result of service status
+		statusErr error
+		want      ServiceStatus
+		wantErr   bool
+	}{
+		{
+			name:      "running",
+			chkconfig: "kubelet  0:off 1:off 2:on 3:on 4:on 5:on 6:off",
+			want:      ServiceStatus{Name: "kubelet", LoadState: "loaded", ActiveState: "active", SubState: "running", UnitFileState: "enabled"},
+		},
+		{
+			name:      "not running and disabled",
+			chkconfig: "kubelet  0:off 1:off 2:off 3:off 4:off 5:off 6:off",
+			statusErr: &executil.ExitError{ExitCode: 3},
+			want:      ServiceStatus{Name: "kubelet", LoadState: "loaded", ActiveState: "inactive", SubState: "dead", UnitFileState: "disabled"},
+		},
+		{
+			name:      "dead with pid file",
+			statusErr: &executil.ExitError{ExitCode: 1},
+			want:      ServiceStatus{Name: "kubelet", LoadState: "loaded", ActiveState: "failed", SubState: "failed", Result: "exit-code", UnitFileState: "enabled"},
+		},
+		{
+			name:      "dead with lock file",
+			statusErr: &executil.ExitError{ExitCode: 2},
+			want:      ServiceStatus{Name: "kubelet", LoadState: "loaded", ActiveState: "failed", SubState: "failed", Result: "exit-code", UnitFileState: "enabled"},
+		},
+		{
+			name:      "unknown service",
+			statusErr: &executil.ExitError{ExitCode: 4},
+			want:      ServiceStatus{Name: "kubelet", LoadState: "not-found", ActiveState: "inactive", SubState: "dead", UnitFileState: "enabled"},
+		},
+		{
+			name:      "unexpected code",
+			statusErr: &executil.ExitError{ExitCode: 150},
+			want:      ServiceStatus{Name: "kubelet", LoadState: "loaded", ActiveState: "inactive", SubState: "unknown", UnitFileState: "enabled"},
+		},
+		{
+			name:      "service not runnable",
+			statusErr: errors.New("exec: \"service\": executable file not found in $PATH"),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, dir := range []string{"etc/init.d", "etc/rc3.d"} {
+				if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(filepath.Join(root, "etc/init.d/kubelet"), []byte("#!/bin/sh\n"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("../init.d/kubelet", filepath.Join(root, "etc/rc3.d/S20kubelet")); err != nil {
+				t.Fatal(err)
+			}
+
+			fake := executil.NewFakeExecutor()
+			fake.Paths = map[string]string{"service": "/usr/sbin/service"}
+			if tt.chkconfig != "" {
+				fake.Paths["chkconfig"] = "/sbin/chkconfig"
+				fake.SetResult("chkconfig --list kubelet", tt.chkconfig, nil)
+			}
+			fake.SetResult("service kubelet status", "", tt.statusErr)
+
+			status, err := (&SysVInitSystem{Exec: fake, Root: root}).ServiceStatus("kubelet")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ServiceStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *status != tt.want {
+				t.Errorf("got %+v, want %+v", *status, tt.want)
+			}
+		})
+	}
+
+	// without an init script the service does not exist, service is not asked
+	fake := executil.NewFakeExecutor()
+	status, err := (&SysVInitSystem{Exec: fake, Root: t.TempDir()}).ServiceStatus("kubelet")
+	if err != nil || status.Exists() || len(fake.Calls) != 0 {
+		t.Errorf("unexpected status %+v, error %v and calls %v", status, err, fake.CommandLines())
+	}
+}
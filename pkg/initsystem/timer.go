@@ -0,0 +1,217 @@
+package initsystem
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+const SectionTimer = "Timer"
+
+// Timer is a timer unit with the oneshot service it activates, both named after Name
+type Timer struct {
+	Name        string
+	Description string
+	// OnCalendar are calendar expressions like "daily" or "Mon *-*-* 03:00:00"
+	OnCalendar []string
+	// Persistent triggers the service on boot if a trigger was missed while the host was down
+	Persistent bool
+	// RandomizedDelaySec spreads triggers of many hosts, e.g. "30min"
+	RandomizedDelaySec string
+	Command            []string
+	// Properties are extra [Service] options, e.g. "MemoryMax": "512M" or "Nice": "10"
+	Properties map[string]string
+}
+
+// TimerStatus is the schedule state of an installed timer
+type TimerStatus struct {
+	Name string `json:"name"`
+	// Unit is the unit the timer activates
+	Unit        string    `json:"unit"`
+	Active      bool      `json:"active"`
+	NextElapse  time.Time `json:"nextElapse,omitempty"`
+	LastTrigger time.Time `json:"lastTrigger,omitempty"`
+}
+
+// TimerManager installs timers with their services
+type TimerManager struct {
+	Units *UnitManager
+	exec  executil.Executor
+}
+
+// NewTimerManager returns a TimerManager installing to DefaultUnitDir, exec runs systemctl and
+// systemd-analyze, nil runs them on the host
+func NewTimerManager(exec executil.Executor) *TimerManager {
+	if exec == nil {
+		exec = executil.New()
+	}
+	return &TimerManager{Units: NewUnitManager(exec), exec: exec}
+}
+
+// timerName returns the timer unit for a name given with or without ".timer" or ".service"
+func timerName(name string) string {
+	if strings.HasSuffix(name, ".timer") {
+		return name
+	}
+	return strings.TrimSuffix(unitName(name), ".service") + ".timer"
+}
+
+// serviceName returns the service a timer activates for a name given with or without ".timer" or
+// ".service"
+func serviceName(name string) string {
+	return unitName(strings.TrimSuffix(name, ".timer"))
+}
+
+// Units returns the timer and service unit files of t
+func (t *Timer) Units() (timer *UnitFile, service *UnitFile, err error) {
+
+	if t.Name == "" || len(t.Command) == 0 {
+		return nil, nil, fmt.Errorf("timer needs a name and a command")
+	}
+	if len(t.OnCalendar) == 0 {
+		return nil, nil, fmt.Errorf("timer %s needs an OnCalendar schedule", t.Name)
+	}
+
+	service = &UnitFile{}
+	service.Unit.Description = t.Description
+	service.Service.Type = "oneshot"
+	service.Service.ExecStart = []string{ExecCommandLine(t.Command...)}
+	for _, k := range sortedKeys(t.Properties) {
+		service.Extra = append(service.Extra, UnitOption{Section: SectionService, Name: k, Value: t.Properties[k]})
+	}
+
+	timer = &UnitFile{}
+	timer.Unit.Description = t.Description
+	for _, cal := range t.OnCalendar {
+		timer.Extra = append(timer.Extra, UnitOption{Section: SectionTimer, Name: "OnCalendar", Value: cal})
+	}
+	if t.Persistent {
+		timer.Extra = append(timer.Extra, UnitOption{Section: SectionTimer, Name: "Persistent", Value: "true"})
+	}
+	if t.RandomizedDelaySec != "" {
+		timer.Extra = append(timer.Extra, UnitOption{Section: SectionTimer, Name: "RandomizedDelaySec", Value: t.RandomizedDelaySec})
+	}
+	timer.Extra = append(timer.Extra, UnitOption{Section: SectionTimer, Name: "Unit", Value: serviceName(t.Name)})
+	timer.Install.WantedBy = []string{"timers.target"}
+	return timer, service, nil
+}
+
+// Install writes the timer and its service, then enables and starts the timer. Calendar expressions
+// are checked with `systemd-analyze calendar` if Units.Verify is set. It returns the files written.
+func (m *TimerManager) Install(t Timer) ([]string, error) {
+
+	timer, service, err := t.Units()
+	if err != nil {
+		return nil, err
+	}
+	if m.Units.Verify {
+		if err := m.VerifyCalendar(t.OnCalendar...); err != nil {
+			return nil, err
+		}
+	}
+
+	changed, err := m.Units.Install(
+		UnitInstall{Name: serviceName(t.Name), File: service},
+		UnitInstall{Name: timerName(t.Name), File: timer},
+	)
+	if err != nil {
+		return changed, err
+	}
+	return changed, m.Enable(t.Name)
+}
+
+// Enable enables and starts the timer
+func (m *TimerManager) Enable(name string) error {
+	if _, err := m.exec.Run(nil, "systemctl", "enable", "--now", timerName(name)); err != nil {
+		return fmt.Errorf("failed to enable timer %s: %v", timerName(name), err)
+	}
+	return nil
+}
+
+// Disable stops the timer and disables it, the service is left installed
+func (m *TimerManager) Disable(name string) error {
+	if _, err := m.exec.Run(nil, "systemctl", "disable", "--now", timerName(name)); err != nil {
+		return fmt.Errorf("failed to disable timer %s: %v", timerName(name), err)
+	}
+	return nil
+}
+
+// Remove disables the timer and removes it together with its service. Timers which are disabled or
+// not installed already are not an error, systemctl exits non-zero for them.
+func (m *TimerManager) Remove(name string) ([]string, error) {
+	if _, err := m.exec.Run(nil, "systemctl", "disable", "--now", timerName(name)); err != nil && executil.ExitCode(err) < 0 {
+		return nil, fmt.Errorf("failed to disable timer %s: %v", timerName(name), err)
+	}
+	return m.Units.Remove(UnitInstall{Name: timerName(name)}, UnitInstall{Name: serviceName(name)})
+}
+
+// VerifyCalendar checks calendar expressions with `systemd-analyze calendar`, if it is available
+func (m *TimerManager) VerifyCalendar(specs ...string) error {
+	if _, err := m.exec.LookPath("systemd-analyze"); err != nil {
+		return nil
+	}
+	for _, spec := range specs {
+		if _, err := m.exec.Run(nil, "systemd-analyze", "calendar", spec); err != nil {
+			return fmt.Errorf("invalid calendar expression %q: %v", spec, err)
+		}
+	}
+	return nil
+}
+
+// List returns the state and next trigger time of every loaded timer
+func (m *TimerManager) List() ([]TimerStatus, error) {
+
+	out, err := m.exec.Run(nil, "systemctl", "list-units", "--type=timer", "--all", "--no-legend", "--plain", "--no-pager")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list timers: %v", err)
+	}
+
+	timers := make([]TimerStatus, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasSuffix(fields[0], ".timer") {
+			continue
+		}
+		status, err := m.Status(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		timers = append(timers, *status)
+	}
+	return timers, nil
+}
+
+// Status returns the state and next trigger time of the timer
+func (m *TimerManager) Status(name string) (*TimerStatus, error) {
+
+	name = timerName(name)
+	out, err := m.exec.Run(nil, "systemctl", "show", "--property=Id,Unit,ActiveState,NextElapseUSecRealtime,LastTriggerUSec", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to show timer %s: %v", name, err)
+	}
+
+	status := &TimerStatus{Name: name}
+	for _, line := range strings.Split(string(out), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "Unit":
+			status.Unit = value
+		case "ActiveState":
+			status.Active = value == "active"
+		case "NextElapseUSecRealtime":
+			status.NextElapse, err = parseShowTimestamp(value)
+		case "LastTriggerUSec":
+			status.LastTrigger, err = parseShowTimestamp(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of timer %s: %v", kv[0], name, err)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,99 @@
+package initsystem
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestTimerManagerRemove(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// script prepares the result of systemctl disable
+		script  func(f *executil.FakeExecutor)
+		wantErr bool
+	}{
+		{name: "enabled"},
+		{
+			name: "not installed",
+			script: func(f *executil.FakeExecutor) {
+				f.SetExitCode("systemctl disable", 1, "Failed to disable unit: Unit file backup.timer does not exist.")
+			},
+		},
+		{
+			name: "systemctl not runnable",
+			script: func(f *executil.FakeExecutor) {
+				f.SetResult("systemctl disable", "", errors.New("exec: \"systemctl\": executable file not found in $PATH"))
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			if tt.script != nil {
+				tt.script(fake)
+			}
+			m := NewTimerManager(fake)
+			m.Units.Dir = t.TempDir()
+			timer := filepath.Join(m.Units.Dir, "backup.timer")
+			if err := os.WriteFile(timer, []byte("[Timer]\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			removed, err := m.Remove("backup")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Remove() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(removed) != 1 || removed[0] != timer {
+				t.Errorf("removed %v, want %s", removed, timer)
+			}
+		})
+	}
+}
+
+func TestTimerManagerInstallNames(t *testing.T) {
+
+	for _, name := range []string{"backup", "backup.service", "backup.timer"} {
+		t.Run(name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			m := NewTimerManager(fake)
+			m.Units.Dir = t.TempDir()
+			timer := Timer{Name: name, OnCalendar: []string{"daily"}, Command: []string{"/usr/local/bin/backup"}}
+
+			changed, err := m.Install(timer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{filepath.Join(m.Units.Dir, "backup.service"), filepath.Join(m.Units.Dir, "backup.timer")}
+			if !reflect.DeepEqual(changed, want) {
+				t.Errorf("installed %v, want %v", changed, want)
+			}
+			service, err := os.ReadFile(want[0])
+			if err != nil || !strings.Contains(string(service), "ExecStart=/usr/local/bin/backup") {
+				t.Errorf("unexpected service %s: %v", service, err)
+			}
+			content, err := os.ReadFile(want[1])
+			if err != nil || !strings.Contains(string(content), "Unit=backup.service") {
+				t.Errorf("unexpected timer %s: %v", content, err)
+			}
+
+			removed, err := m.Remove(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(removed, []string{want[1], want[0]}) {
+				t.Errorf("removed %v, want %v", removed, []string{want[1], want[0]})
+			}
+		})
+	}
+}
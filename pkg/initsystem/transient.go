@@ -0,0 +1,105 @@
+package initsystem
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+// TransientService is a command run as a service which only exists until it is stopped, like
+// `systemd-run`
+type TransientService struct {
+	// Name of the unit, a "run-<timestamp>.service" name is generated if empty
+	Name        string
+	Description string
+	Command     []string
+	// Properties are unit and service properties, e.g. "MemoryMax": "512M" or "CPUQuota": "50%"
+	Properties  map[string]string
+	Environment map[string]string
+	// OnCalendar starts the service from a transient timer on this schedule instead of right away
+	OnCalendar string
+	// Wait blocks until the command exited and fails if it did not succeed, it cannot be combined
+	// with OnCalendar
+	Wait bool
+	// Collect unloads the unit once it finished, even if it failed
+	Collect bool
+}
+
+// Args returns the systemd-run arguments starting the service
+func (t *TransientService) Args() ([]string, error) {
+
+	if len(t.Command) == 0 {
+		return nil, fmt.Errorf("transient service needs a command")
+	}
+	if t.Wait && t.OnCalendar != "" {
+		return nil, fmt.Errorf("cannot wait for a service started by a timer")
+	}
+
+	args := []string{"--unit", unitName(t.Name), "--quiet"}
+	if t.Description != "" {
+		args = append(args, "--description", t.Description)
+	}
+	for _, k := range sortedKeys(t.Properties) {
+		args = append(args, "--property", k+"="+t.Properties[k])
+	}
+	for _, k := range sortedKeys(t.Environment) {
+		args = append(args, "--setenv", k+"="+t.Environment[k])
+	}
+	if t.OnCalendar != "" {
+		args = append(args, "--on-calendar", t.OnCalendar)
+	}
+	if t.Wait {
+		args = append(args, "--wait")
+	}
+	if t.Collect {
+		args = append(args, "--collect")
+	}
+	args = append(args, "--")
+	return append(args, t.Command...), nil
+}
+
+// RunTransient starts the transient service with systemd-run and returns the name of its unit. exec
+// runs systemd-run, nil runs it on the host.
+func RunTransient(exec executil.Executor, t TransientService) (string, error) {
+
+	if exec == nil {
+		exec = executil.New()
+	}
+	if t.Name == "" {
+		t.Name = fmt.Sprintf("run-%d", time.Now().UnixNano())
+	}
+	args, err := t.Args()
+	if err != nil {
+		return "", err
+	}
+	if _, err := exec.Run(nil, "systemd-run", args...); err != nil {
+		return "", fmt.Errorf("failed to run transient service %s: %v", unitName(t.Name), err)
+	}
+	return unitName(t.Name), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ExecCommandLine quotes args for Exec*= options, escaping the specifier "%" and variable "$" signs
+func ExecCommandLine(args ...string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		arg = strings.ReplaceAll(arg, "%", "%%")
+		arg = strings.ReplaceAll(arg, "$", "$$")
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\;") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted = append(quoted, arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,251 @@
+package initsystem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+)
+
+const (
+	SectionUnit    = "Unit"
+	SectionService = "Service"
+	SectionInstall = "Install"
+)
+
+// unitTypes are the suffixes systemd accepts as unit type, names without one are services
+var unitTypes = map[string]bool{
+	".service": true, ".socket": true, ".device": true, ".mount": true, ".automount": true, ".swap": true,
+	".target": true, ".path": true, ".timer": true, ".slice": true, ".scope": true,
+}
+
+// UnitOption is a single "Name=Value" line of a section
+type UnitOption struct {
+	Section string
+	Name    string
+	Value   string
+}
+
+// UnitFile is a systemd unit or drop-in. Options which can be given several times are lists holding
+// one entry per line, an empty entry serializes as "Name=" which resets the list in drop-ins.
+type UnitFile struct {
+	Unit    UnitSection
+	Service ServiceSection
+	Install InstallSection
+	// Extra holds options without a typed field and other sections like [Socket] or [Timer], in file order
+	Extra []UnitOption
+}
+
+// UnitSection is the [Unit] section
+type UnitSection struct {
+	Description         string
+	Documentation       []string
+	Requires            []string
+	Wants               []string
+	BindsTo             []string
+	PartOf              []string
+	Conflicts           []string
+	Before              []string
+	After               []string
+	ConditionPathExists []string
+}
+
+// ServiceSection is the [Service] section
+type ServiceSection struct {
+	Type             string
+	User             string
+	Group            string
+	WorkingDirectory string
+	Environment      []string
+	EnvironmentFile  []string
+	ExecStartPre     []string
+	ExecStart        []string
+	ExecStartPost    []string
+	ExecReload       []string
+	ExecStop         []string
+	ExecStopPost     []string
+	Restart          string
+	RestartSec       string
+	TimeoutStartSec  string
+	TimeoutStopSec   string
+	KillMode         string
+	Delegate         string
+	LimitNOFILE      string
+	LimitNPROC       string
+	LimitCORE        string
+	TasksMax         string
+	OOMScoreAdjust   string
+}
+
+// InstallSection is the [Install] section
+type InstallSection struct {
+	WantedBy   []string
+	RequiredBy []string
+	Alias      []string
+	Also       []string
+}
+
+type unitField struct {
+	section string
+	name    string
+	// value is a *string or a *[]string
+	value interface{}
+}
+
+// fields lists the typed options in the order they are serialized
+func (u *UnitFile) fields() []unitField {
+	return []unitField{
+		{SectionUnit, "Description", &u.Unit.Description},
+		{SectionUnit, "Documentation", &u.Unit.Documentation},
+		{SectionUnit, "Requires", &u.Unit.Requires},
+		{SectionUnit, "Wants", &u.Unit.Wants},
+		{SectionUnit, "BindsTo", &u.Unit.BindsTo},
+		{SectionUnit, "PartOf", &u.Unit.PartOf},
+		{SectionUnit, "Conflicts", &u.Unit.Conflicts},
+		{SectionUnit, "Before", &u.Unit.Before},
+		{SectionUnit, "After", &u.Unit.After},
+		{SectionUnit, "ConditionPathExists", &u.Unit.ConditionPathExists},
+
+		{SectionService, "Type", &u.Service.Type},
+		{SectionService, "User", &u.Service.User},
+		{SectionService, "Group", &u.Service.Group},
+		{SectionService, "WorkingDirectory", &u.Service.WorkingDirectory},
+		{SectionService, "Environment", &u.Service.Environment},
+		{SectionService, "EnvironmentFile", &u.Service.EnvironmentFile},
+		{SectionService, "ExecStartPre", &u.Service.ExecStartPre},
+		{SectionService, "ExecStart", &u.Service.ExecStart},
+		{SectionService, "ExecStartPost", &u.Service.ExecStartPost},
+		{SectionService, "ExecReload", &u.Service.ExecReload},
+		{SectionService, "ExecStop", &u.Service.ExecStop},
+		{SectionService, "ExecStopPost", &u.Service.ExecStopPost},
+		{SectionService, "Restart", &u.Service.Restart},
+		{SectionService, "RestartSec", &u.Service.RestartSec},
+		{SectionService, "TimeoutStartSec", &u.Service.TimeoutStartSec},
+		{SectionService, "TimeoutStopSec", &u.Service.TimeoutStopSec},
+		{SectionService, "KillMode", &u.Service.KillMode},
+		{SectionService, "Delegate", &u.Service.Delegate},
+		{SectionService, "LimitNOFILE", &u.Service.LimitNOFILE},
+		{SectionService, "LimitNPROC", &u.Service.LimitNPROC},
+		{SectionService, "LimitCORE", &u.Service.LimitCORE},
+		{SectionService, "TasksMax", &u.Service.TasksMax},
+		{SectionService, "OOMScoreAdjust", &u.Service.OOMScoreAdjust},
+
+		{SectionInstall, "WantedBy", &u.Install.WantedBy},
+		{SectionInstall, "RequiredBy", &u.Install.RequiredBy},
+		{SectionInstall, "Alias", &u.Install.Alias},
+		{SectionInstall, "Also", &u.Install.Also},
+	}
+}
+
+// ParseUnitFile parses a unit file or drop-in, e.g. one rendered with tmpl.Render
+func ParseUnitFile(r io.Reader) (*UnitFile, error) {
+
+	opts, err := unit.DeserializeOptions(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse unit file: %v", err)
+	}
+
+	u := &UnitFile{}
+	typed := make(map[string]interface{})
+	for _, f := range u.fields() {
+		typed[f.section+"."+f.name] = f.value
+	}
+
+	for _, opt := range opts {
+		switch v := typed[opt.Section+"."+opt.Name].(type) {
+		case *string:
+			// a later assignment overrides an earlier one
+			*v = opt.Value
+		case *[]string:
+			*v = append(*v, opt.Value)
+		default:
+			u.Extra = append(u.Extra, UnitOption{Section: opt.Section, Name: opt.Name, Value: opt.Value})
+		}
+	}
+	return u, nil
+}
+
+// ParseUnitFileString parses unit file content
+func ParseUnitFileString(content string) (*UnitFile, error) {
+	return ParseUnitFile(strings.NewReader(content))
+}
+
+// Options flattens the unit into options ordered [Unit], [Service], [Install], then other sections
+func (u *UnitFile) Options() []UnitOption {
+
+	opts := make([]UnitOption, 0)
+	for _, section := range []string{SectionUnit, SectionService, SectionInstall} {
+		for _, f := range u.fields() {
+			if f.section != section {
+				continue
+			}
+			switch v := f.value.(type) {
+			case *string:
+				if *v != "" {
+					opts = append(opts, UnitOption{Section: section, Name: f.name, Value: *v})
+				}
+			case *[]string:
+				for _, value := range *v {
+					opts = append(opts, UnitOption{Section: section, Name: f.name, Value: value})
+				}
+			}
+		}
+		for _, opt := range u.Extra {
+			if opt.Section == section {
+				opts = append(opts, opt)
+			}
+		}
+	}
+	for _, opt := range u.Extra {
+		if opt.Section != SectionUnit && opt.Section != SectionService && opt.Section != SectionInstall {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// Serialize renders the unit file, the output is stable so it can be compared with the installed file
+func (u *UnitFile) Serialize() ([]byte, error) {
+
+	opts := u.Options()
+	unitOpts := make([]*unit.UnitOption, 0, len(opts))
+	for _, opt := range opts {
+		// values may span lines only as "\" continuations
+		if strings.Contains(strings.ReplaceAll(opt.Value, "\\\n", ""), "\n") {
+			return nil, fmt.Errorf("value of %s.%s must not contain a newline", opt.Section, opt.Name)
+		}
+		unitOpts = append(unitOpts, unit.NewUnitOption(opt.Section, opt.Name, opt.Value))
+	}
+	content, err := ioutil.ReadAll(unit.Serialize(unitOpts))
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func (u *UnitFile) String() string {
+	content, err := u.Serialize()
+	if err != nil {
+		return ""
+	}
+	return string(content)
+}
+
+// Equal returns true if both units serialize to the same content
+func (u *UnitFile) Equal(other *UnitFile) bool {
+	a, errA := u.Serialize()
+	b, errB := other.Serialize()
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// unitName appends ".service" to names without a unit type, like systemctl does
+func unitName(service string) string {
+	if unitTypes[path.Ext(service)] {
+		return service
+	}
+	return service + ".service"
+}
@@ -0,0 +1,240 @@
+package initsystem
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultUnitDir is where locally installed units live
+	DefaultUnitDir = "/etc/systemd/system"
+
+	unitFilePerm = 0644
+)
+
+// UnitInstall names a unit file, or one of its drop-ins if DropIn is set, e.g. Name "kubelet.service"
+// with DropIn "10-kubeadm.conf" is installed as kubelet.service.d/10-kubeadm.conf
+type UnitInstall struct {
+	Name   string
+	DropIn string
+	File   *UnitFile
+}
+
+// Path returns where the unit or drop-in is installed below dir
+func (i UnitInstall) Path(dir string) string {
+	name := unitName(i.Name)
+	if i.DropIn == "" {
+		return filepath.Join(dir, name)
+	}
+	dropIn := i.DropIn
+	if !strings.HasSuffix(dropIn, ".conf") {
+		dropIn += ".conf"
+	}
+	return filepath.Join(dir, name+".d", dropIn)
+}
+
+func (i UnitInstall) String() string {
+	if i.DropIn == "" {
+		return unitName(i.Name)
+	}
+	return unitName(i.Name) + "/" + i.DropIn
+}
+
+// UnitManager installs and removes unit files and drop-ins, systemd is only reloaded if a file changed
+type UnitManager struct {
+	// Dir is DefaultUnitDir unless changed
+	Dir string
+	// Verify runs `systemd-analyze verify` on units before they are installed, if it is available
+	Verify bool
+
+	exec executil.Executor
+}
+
+// NewUnitManager returns a UnitManager for DefaultUnitDir running commands with exec, nil uses the host
+func NewUnitManager(exec executil.Executor) *UnitManager {
+	if exec == nil {
+		exec = executil.New()
+	}
+	return &UnitManager{Dir: DefaultUnitDir, exec: exec}
+}
+
+func (m *UnitManager) executor() executil.Executor {
+	if m.exec == nil {
+		return executil.New()
+	}
+	return m.exec
+}
+
+// Install writes the units and drop-ins whose content differs from the installed files, then runs one
+// daemon-reload if any changed. It returns the files which were written.
+func (m *UnitManager) Install(units ...UnitInstall) ([]string, error) {
+
+	changed := make([]string, 0)
+	for _, u := range units {
+		if u.Name == "" || u.File == nil {
+			return changed, fmt.Errorf("unit name and file are required")
+		}
+		content, err := u.File.Serialize()
+		if err != nil {
+			return changed, fmt.Errorf("failed to serialize %s: %v", u, err)
+		}
+
+		path := u.Path(m.Dir)
+		current, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return changed, err
+		}
+		if err == nil && bytes.Equal(current, content) {
+			continue
+		}
+
+		if m.Verify && u.DropIn == "" {
+			if err := m.VerifyUnit(unitName(u.Name), content); err != nil {
+				return changed, err
+			}
+		}
+		if err := writeFileAtomic(path, content); err != nil {
+			return changed, fmt.Errorf("failed to install %s: %v", u, err)
+		}
+		klog.V(2).Infof("installed %s", path)
+		changed = append(changed, path)
+	}
+
+	if len(changed) > 0 {
+		if err := m.reload(); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// Remove deletes the units and drop-ins, a unit's drop-in directory is removed with it. systemd is
+// reloaded once if anything was removed. It returns the paths which were removed.
+func (m *UnitManager) Remove(units ...UnitInstall) ([]string, error) {
+
+	removed := make([]string, 0)
+	for _, u := range units {
+		path := u.Path(m.Dir)
+		paths := []string{path}
+		if u.DropIn == "" {
+			paths = append(paths, path+".d")
+		}
+		for _, p := range paths {
+			if _, err := os.Lstat(p); os.IsNotExist(err) {
+				continue
+			}
+			if err := os.RemoveAll(p); err != nil {
+				return removed, fmt.Errorf("failed to remove %s: %v", p, err)
+			}
+			removed = append(removed, p)
+		}
+		if u.DropIn != "" {
+			// drop the directory once the last drop-in is gone, ignoring the error if it is not empty
+			_ = os.Remove(filepath.Dir(path))
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := m.reload(); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// Installed reads an installed unit or drop-in, it returns nil if the file does not exist
+func (m *UnitManager) Installed(name, dropIn string) (*UnitFile, error) {
+	path := UnitInstall{Name: name, DropIn: dropIn}.Path(m.Dir)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseUnitFile(f)
+}
+
+// DropIns lists the drop-in file names installed for the unit
+func (m *UnitManager) DropIns(name string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(m.Dir, unitName(name)+".d"))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dropIns := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".conf") {
+			dropIns = append(dropIns, e.Name())
+		}
+	}
+	return dropIns, nil
+}
+
+// VerifyUnit checks content with `systemd-analyze verify`, it returns nil if systemd-analyze is not installed
+func (m *UnitManager) VerifyUnit(name string, content []byte) error {
+
+	if _, err := m.executor().LookPath("systemd-analyze"); err != nil {
+		klog.V(2).Infof("systemd-analyze not found, skip verifying %s", name)
+		return nil
+	}
+
+	// systemd-analyze derives the unit name from the file name
+	dir, err := ioutil.TempDir("", "unit-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, unitFilePerm); err != nil {
+		return err
+	}
+	if _, err := m.executor().Run(nil, "systemd-analyze", "verify", path); err != nil {
+		return fmt.Errorf("invalid unit %s: %v", name, err)
+	}
+	return nil
+}
+
+func (m *UnitManager) reload() error {
+	if _, err := m.executor().Run(nil, "systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("failed to reload systemd: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path by renaming a temporary file, so systemd never reads a partial unit
+func writeFileAtomic(path string, content []byte) error {
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(unitFilePerm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,217 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultWaitInterval = 1 * time.Second
+	DefaultStablePeriod = 5 * time.Second
+	DefaultMaxRestarts  = 3
+	DefaultJournalLines = 20
+)
+
+// WaitOptions tunes WaitActive and WaitInactive, zero values use the defaults
+type WaitOptions struct {
+	// Interval between two status polls
+	Interval time.Duration
+	// Stable is how long the service has to stay active without restarting before WaitActive returns
+	Stable time.Duration
+	// MaxRestarts is how many automatic restarts during the wait are tolerated before it is reported as
+	// crash looping, nil uses DefaultMaxRestarts and 0 tolerates none
+	MaxRestarts *int
+	// JournalLines is the number of journal lines attached to a ServiceError, negative disables it
+	JournalLines int
+	// Journal is read for the lines attached to a ServiceError, nil runs journalctl on the host
+	Journal JournalSource
+}
+
+func (o *WaitOptions) complete() {
+	if o.Interval <= 0 {
+		o.Interval = DefaultWaitInterval
+	}
+	if o.Stable <= 0 {
+		o.Stable = DefaultStablePeriod
+	}
+	if o.MaxRestarts == nil {
+		maxRestarts := DefaultMaxRestarts
+		o.MaxRestarts = &maxRestarts
+	}
+	if o.JournalLines == 0 {
+		o.JournalLines = DefaultJournalLines
+	}
+}
+
+// ServiceError reports a service which did not reach the expected state, with its last status and journal
+type ServiceError struct {
+	Service string
+	Reason  string
+	Status  *ServiceStatus
+	Journal []string
+}
+
+func (e *ServiceError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "service %s %s", e.Service, e.Reason)
+	if e.Status != nil {
+		fmt.Fprintf(&b, " (state %s/%s", e.Status.ActiveState, e.Status.SubState)
+		if e.Status.Result != "" && e.Status.Result != "success" {
+			fmt.Fprintf(&b, ", result %s, exit status %d", e.Status.Result, e.Status.ExecMainStatus)
+		}
+		if e.Status.NRestarts > 0 {
+			fmt.Fprintf(&b, ", %d restarts", e.Status.NRestarts)
+		}
+		b.WriteString(")")
+	}
+	if len(e.Journal) > 0 {
+		b.WriteString("\nlast journal lines:\n")
+		b.WriteString(strings.Join(e.Journal, "\n"))
+	}
+	return b.String()
+}
+
+// WaitActive polls the service until it has been active for opts.Stable without restarting. It fails
+// early if the service does not exist, failed, or restarted more than opts.MaxRestarts times.
+func WaitActive(ctx context.Context, sys InitSystem, service string, opts WaitOptions) (*ServiceStatus, error) {
+
+	opts.complete()
+
+	var (
+		status       *ServiceStatus
+		err          error
+		baseRestarts = -1
+		activeSince  time.Time
+		restarts     int
+	)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		status, err = sys.ServiceStatus(service)
+		if err != nil {
+			klog.V(4).Infof("failed to get status of %s: %v", service, err)
+		} else {
+			if !status.Exists() {
+				return status, newServiceError(service, "does not exist", status, opts)
+			}
+			if baseRestarts < 0 {
+				baseRestarts = status.NRestarts
+			}
+
+			// every restart triggered by Restart= bumps NRestarts and resets the stable period
+			if status.NRestarts-baseRestarts > restarts {
+				restarts = status.NRestarts - baseRestarts
+				activeSince = time.Time{}
+			}
+			if restarts > *opts.MaxRestarts {
+				return status, newServiceError(service, fmt.Sprintf("is crash looping, restarted %d times", restarts), status, opts)
+			}
+
+			switch {
+			case status.ActiveState == "failed":
+				return status, newServiceError(service, "failed", status, opts)
+			case status.IsActive() && status.SubState != "auto-restart":
+				if activeSince.IsZero() {
+					activeSince = time.Now()
+				}
+				if time.Since(activeSince) >= opts.Stable {
+					return status, nil
+				}
+			default:
+				activeSince = time.Time{}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, newServiceError(service, "did not become active: "+ctx.Err().Error(), status, opts)
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitInactive polls the service until it is inactive, failed or unknown. A status which can not be
+// read counts as unknown, like `systemctl is-active` printing "unknown".
+func WaitInactive(ctx context.Context, sys InitSystem, service string, opts WaitOptions) (*ServiceStatus, error) {
+
+	opts.complete()
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		status, err := sys.ServiceStatus(service)
+		if err != nil {
+			klog.V(4).Infof("failed to get status of %s, assume it is inactive: %v", service, err)
+			return &ServiceStatus{Name: service}, nil
+		}
+		if status.IsInactive() || !status.Exists() {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, newServiceError(service, "did not stop: "+ctx.Err().Error(), status, opts)
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnsureStartService enables and starts the service, then waits until it is stably active
+func EnsureStartService(ctx context.Context, service string, opts WaitOptions) error {
+
+	klog.Infof("start service %s", service)
+	sys, err := GetInitSystem()
+	if err != nil {
+		return err
+	}
+	return ensureStartService(ctx, sys, service, opts)
+}
+
+func ensureStartService(ctx context.Context, sys InitSystem, service string, opts WaitOptions) error {
+
+	// complete first, so a failing start attaches the default journal lines as well
+	opts.complete()
+	if !sys.ServiceExists(service) {
+		return fmt.Errorf("service %s does not exist", service)
+	}
+	if err := sys.ServiceEnable(service); err != nil {
+		return fmt.Errorf("failed to enable service %s: %v", service, err)
+	}
+	if err := sys.ServiceStart(service); err != nil {
+		status, _ := sys.ServiceStatus(service)
+		return newServiceError(service, "failed to start: "+err.Error(), status, opts)
+	}
+	_, err := WaitActive(ctx, sys, service, opts)
+	return err
+}
+
+func newServiceError(service, reason string, status *ServiceStatus, opts WaitOptions) *ServiceError {
+	serviceErr := &ServiceError{Service: service, Reason: reason, Status: status}
+	if opts.JournalLines <= 0 {
+		return serviceErr
+	}
+
+	journal := opts.Journal
+	if journal == nil {
+		journal = NewJournalctl(nil)
+	}
+	entries, err := journal.Entries(context.Background(), JournalQuery{Unit: service, Lines: opts.JournalLines})
+	if err != nil {
+		klog.V(4).Infof("failed to read journal of %s: %v", service, err)
+		return serviceErr
+	}
+	serviceErr.Journal = make([]string, 0, len(entries))
+	for _, e := range entries {
+		serviceErr.Journal = append(serviceErr.Journal, e.String())
+	}
+	return serviceErr
+}
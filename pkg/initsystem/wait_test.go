@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package initsystem
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestEnsureStartServiceFailure(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		journalLines int
+		want         []string
+	}{
+		{name: "default lines", want: []string{"kubelet: flag provided but not defined", "kubelet: exit status 1"}},
+		{name: "one line", journalLines: 1, want: []string{"kubelet: exit status 1"}},
+		{name: "disabled", journalLines: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			fake.SetResult("systemctl show", "Id=kubelet.service\nLoadState=loaded\nActiveState=failed\nSubState=failed\n", nil)
+			fake.SetExitCode("systemctl start", 1, "Job for kubelet.service failed because the control process exited with error code.")
+			journal := NewFakeJournal()
+			journal.Log("kubelet", "flag provided but not defined")
+			journal.Log("kubelet", "exit status 1")
+
+			err := ensureStartService(context.Background(), NewSystemdInitSystem(fake), "kubelet", WaitOptions{JournalLines: tt.journalLines, Journal: journal})
+			var serviceErr *ServiceError
+			if !errors.As(err, &serviceErr) {
+				t.Fatalf("expected a ServiceError, got %v", err)
+			}
+			if len(serviceErr.Journal) != len(tt.want) {
+				t.Fatalf("got journal %v, want %v", serviceErr.Journal, tt.want)
+			}
+			for i, line := range serviceErr.Journal {
+				if !strings.HasSuffix(line, tt.want[i]) {
+					t.Errorf("got journal line %q, want %q", line, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package ipam
+
+import (
+	"fmt"
+	"github.com/QQGoblin/go-sdk/pkg/network"
+	"github.com/pkg/errors"
+	"net"
+)
+
+/**
+从地址池中分配子网（如：每个节点的 pod 网段）和单个地址（如：service 的固定 IP），分配结果通过 Store 持久化
+*/
+
+var (
+	ErrPoolExhausted = errors.New("ipam pool exhausted")
+	ErrInUse         = errors.New("ipam address in use")
+	ErrOutOfPool     = errors.New("ipam address out of pool")
+)
+
+type Allocator struct {
+	pool     *net.IPNet
+	gateway  net.IP
+	excluded []*net.IPNet
+	store    Store
+}
+
+type Option func(a *Allocator)
+
+// WithGateway overrides the gateway address, which defaults to the first host address of the pool
+func WithGateway(gateway net.IP) Option {
+	return func(a *Allocator) {
+		a.gateway = gateway
+	}
+}
+
+// WithExcluded keeps the given ranges out of every allocation
+func WithExcluded(cidrs ...*net.IPNet) Option {
+	return func(a *Allocator) {
+		a.excluded = append(a.excluded, cidrs...)
+	}
+}
+
+func NewAllocator(pool *net.IPNet, store Store, opts ...Option) (*Allocator, error) {
+
+	if pool == nil {
+		return nil, fmt.Errorf("ipam pool may not be nil")
+	}
+	if _, err := network.NewCIDRSet(pool); err != nil {
+		return nil, errors.Wrapf(err, "invalid pool %s", pool)
+	}
+
+	a := &Allocator{
+		pool:  pool,
+		store: store,
+	}
+
+	// the first host address is the gateway by convention
+	ones, bits := pool.Mask.Size()
+	if bits-ones > 1 {
+		it, err := network.SubnetAllIPs(pool)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pool %s", pool)
+		}
+		it.Next()
+		a.gateway = it.Next()
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if _, err := network.NewCIDRSet(a.excluded...); err != nil {
+		return nil, errors.Wrap(err, "invalid excluded range")
+	}
+	return a, nil
+}
+
+// Pool returns the network addresses are allocated from
+func (a *Allocator) Pool() *net.IPNet {
+	return a.pool
+}
+
+// Gateway returns the address never handed out by AllocateIP, or nil if there is none
+func (a *Allocator) Gateway() net.IP {
+	return a.gateway
+}
+
+// Allocations returns a snapshot of the allocated subnets and ips
+func (a *Allocator) Allocations() (*State, error) {
+	return a.store.Load()
+}
+
+// AllocateSubnet hands out the lowest free /prefixLen subnet of the pool to owner, subnets never
+// contain the network, broadcast and gateway addresses of the pool.
+// If owner already holds a subnet of that size it is returned again.
+func (a *Allocator) AllocateSubnet(owner string, prefixLen int) (*net.IPNet, error) {
+
+	poolBits, bits := a.pool.Mask.Size()
+	if prefixLen < poolBits || prefixLen > bits {
+		return nil, fmt.Errorf("cannot allocate /%d from pool %s", prefixLen, a.pool)
+	}
+
+	var allocated *net.IPNet
+	err := a.store.Update(func(state *State) error {
+		if subnet := ownedSubnet(state, owner, prefixLen); subnet != nil {
+			allocated = subnet
+			return nil
+		}
+
+		used, err := a.unavailable(state)
+		if err != nil {
+			return err
+		}
+		// NextFree skips whole allocated ranges, so large pools are never walked subnet by subnet
+		subnet, err := used.NextFree(a.pool, prefixLen)
+		if err != nil {
+			return errors.Wrapf(ErrPoolExhausted, "no free /%d in %s", prefixLen, a.pool)
+		}
+		state.Subnets[subnet.String()] = owner
+		allocated = subnet
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allocated, nil
+}
+
+// ReserveSubnet records subnet as allocated to owner, e.g. to import existing allocations
+func (a *Allocator) ReserveSubnet(owner string, subnet *net.IPNet) error {
+
+	requested, err := network.NewCIDRSet(subnet)
+	if err != nil {
+		return errors.Wrapf(err, "invalid subnet %s", subnet)
+	}
+	if !mustCIDRSet(a.pool).ContainsCIDR(subnet) {
+		return errors.Wrapf(ErrOutOfPool, "subnet %s is not in %s", subnet, a.pool)
+	}
+
+	return a.store.Update(func(state *State) error {
+		key := requested.String()
+		if holder, ok := state.Subnets[key]; ok {
+			if holder == owner {
+				return nil
+			}
+			return errors.Wrapf(ErrInUse, "subnet %s is allocated to %s", key, holder)
+		}
+
+		used, err := a.unavailable(state)
+		if err != nil {
+			return err
+		}
+		if used.Overlaps(requested) {
+			return errors.Wrapf(ErrInUse, "subnet %s overlaps with %s", key, used.Intersection(requested))
+		}
+		state.Subnets[key] = owner
+		return nil
+	})
+}
+
+// ReleaseSubnet returns subnet to the pool, releasing a free subnet is not an error
+func (a *Allocator) ReleaseSubnet(subnet *net.IPNet) error {
+
+	requested, err := network.NewCIDRSet(subnet)
+	if err != nil {
+		return errors.Wrapf(err, "invalid subnet %s", subnet)
+	}
+	return a.store.Update(func(state *State) error {
+		delete(state.Subnets, requested.String())
+		return nil
+	})
+}
+
+// AllocateIP hands out the lowest free address of the pool to owner,
+// skipping the network, broadcast and gateway addresses.
+func (a *Allocator) AllocateIP(owner string) (net.IP, error) {
+
+	var allocated net.IP
+	err := a.store.Update(func(state *State) error {
+		used, err := a.unavailable(state)
+		if err != nil {
+			return err
+		}
+		_, bits := a.pool.Mask.Size()
+		free, err := used.NextFree(a.pool, bits)
+		if err != nil {
+			return errors.Wrapf(ErrPoolExhausted, "no free address in %s", a.pool)
+		}
+		allocated = free.IP
+		state.IPs[allocated.String()] = owner
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allocated, nil
+}
+
+// ReserveIP records ip as allocated to owner, e.g. a static service ip
+func (a *Allocator) ReserveIP(owner string, ip net.IP) error {
+
+	if !a.pool.Contains(ip) {
+		return errors.Wrapf(ErrOutOfPool, "address %s is not in %s", ip, a.pool)
+	}
+	if a.isReserved(ip) {
+		return errors.Wrapf(ErrInUse, "address %s is reserved", ip)
+	}
+
+	return a.store.Update(func(state *State) error {
+		key := ip.String()
+		if holder, ok := state.IPs[key]; ok {
+			if holder == owner {
+				return nil
+			}
+			return errors.Wrapf(ErrInUse, "address %s is allocated to %s", key, holder)
+		}
+
+		used, err := a.used(state)
+		if err != nil {
+			return err
+		}
+		if used.Contains(ip) {
+			return errors.Wrapf(ErrInUse, "address %s is in an allocated or excluded range", key)
+		}
+		state.IPs[key] = owner
+		return nil
+	})
+}
+
+// ReleaseIP returns ip to the pool, releasing a free address is not an error
+func (a *Allocator) ReleaseIP(ip net.IP) error {
+	return a.store.Update(func(state *State) error {
+		delete(state.IPs, ip.String())
+		return nil
+	})
+}
+
+// Release returns every subnet and address held by owner to the pool
+func (a *Allocator) Release(owner string) error {
+	return a.store.Update(func(state *State) error {
+		for k, v := range state.Subnets {
+			if v == owner {
+				delete(state.Subnets, k)
+			}
+		}
+		for k, v := range state.IPs {
+			if v == owner {
+				delete(state.IPs, k)
+			}
+		}
+		return nil
+	})
+}
+
+// used returns every address which is allocated or excluded
+func (a *Allocator) used(state *State) (*network.CIDRSet, error) {
+
+	used, err := network.NewCIDRSet(a.excluded...)
+	if err != nil {
+		return nil, err
+	}
+	for cidr := range state.Subnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid subnet %s in ipam state", cidr)
+		}
+		if err := used.Insert(subnet); err != nil {
+			return nil, err
+		}
+	}
+	for addr := range state.IPs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %s in ipam state", addr)
+		}
+		if err := used.Insert(hostNet(ip)); err != nil {
+			return nil, err
+		}
+	}
+	return used, nil
+}
+
+// unavailable returns the used addresses together with the reserved addresses of the pool
+func (a *Allocator) unavailable(state *State) (*network.CIDRSet, error) {
+
+	used, err := a.used(state)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range a.reservedIPs() {
+		if err := used.Insert(hostNet(ip)); err != nil {
+			return nil, err
+		}
+	}
+	return used, nil
+}
+
+// isReserved returns true for the network, broadcast and gateway addresses of the pool
+func (a *Allocator) isReserved(ip net.IP) bool {
+	for _, reserved := range a.reservedIPs() {
+		if reserved.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedIPs returns the network, broadcast and gateway addresses of the pool
+func (a *Allocator) reservedIPs() []net.IP {
+
+	reserved := make([]net.IP, 0, 3)
+	if a.gateway != nil {
+		reserved = append(reserved, a.gateway)
+	}
+	ones, bits := a.pool.Mask.Size()
+	if bits-ones <= 1 {
+		// point-to-point networks use every address
+		return reserved
+	}
+	reserved = append(reserved, a.pool.IP.Mask(a.pool.Mask))
+	if bits == 8*net.IPv4len {
+		broadcast := make(net.IP, net.IPv4len)
+		base := a.pool.IP.To4()
+		for i := range broadcast {
+			broadcast[i] = base[i] | ^a.pool.Mask[i]
+		}
+		reserved = append(reserved, broadcast)
+	}
+	return reserved
+}
+
+func ownedSubnet(state *State, owner string, prefixLen int) *net.IPNet {
+	for cidr, holder := range state.Subnets {
+		if holder != owner {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if ones, _ := subnet.Mask.Size(); ones == prefixLen {
+			return subnet
+		}
+	}
+	return nil
+}
+
+func hostNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+}
+
+// mustCIDRSet builds a set from a network already validated by the caller
+func mustCIDRSet(cidr *net.IPNet) *network.CIDRSet {
+	s, err := network.NewCIDRSet(cidr)
+	if err != nil {
+		return &network.CIDRSet{}
+	}
+	return s
+}
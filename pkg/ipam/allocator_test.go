@@ -0,0 +1,77 @@
+package ipam
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"testing"
+)
+
+// memStore keeps the state in memory
+type memStore struct {
+	state *State
+}
+
+func (s *memStore) Load() (*State, error) {
+	return s.state, nil
+}
+
+func (s *memStore) Update(fn func(state *State) error) error {
+	return fn(s.state)
+}
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestAllocateSubnet(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		pool      string
+		opts      []Option
+		prefixLen int
+		want      string
+	}{
+		{name: "skips the gateway", pool: "10.244.0.0/16", prefixLen: 24, want: "10.244.1.0/24"},
+		{name: "skips the broadcast address", pool: "10.244.0.0/30", prefixLen: 32, want: "10.244.0.2/32"},
+		{name: "custom gateway", pool: "10.244.0.0/16", opts: []Option{WithGateway(net.ParseIP("10.244.3.254"))}, prefixLen: 24, want: "10.244.1.0/24"},
+		{name: "ipv6", pool: "fd00::/48", prefixLen: 64, want: "fd00:0:0:1::/64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAllocator(mustCIDR(t, tt.pool), &memStore{state: newState()}, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subnet, err := a.AllocateSubnet("node1", tt.prefixLen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subnet.String() != tt.want {
+				t.Errorf("got %s, want %s", subnet, tt.want)
+			}
+			if a.Gateway() != nil && subnet.Contains(a.Gateway()) {
+				t.Errorf("%s contains the gateway %s", subnet, a.Gateway())
+			}
+		})
+	}
+}
+
+func TestReserveSubnetWithGateway(t *testing.T) {
+
+	a, err := NewAllocator(mustCIDR(t, "10.244.0.0/16"), &memStore{state: newState()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReserveSubnet("node1", mustCIDR(t, "10.244.0.0/24")); !errors.Is(err, ErrInUse) {
+		t.Errorf("expected ErrInUse, got %v", err)
+	}
+	if err := a.ReserveSubnet("node1", mustCIDR(t, "10.244.2.0/24")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,58 @@
+package ipam
+
+import (
+	"github.com/QQGoblin/go-sdk/pkg/kubeutils"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultConfigMapKey = "ipam.json"
+)
+
+// ConfigMapStore persists the state as json under a key of a kubernetes configmap.
+// Concurrent writers are serialized by the optimistic concurrency of the apiserver.
+type ConfigMapStore struct {
+	kubecli   *kubernetes.Clientset
+	namespace string
+	name      string
+	key       string
+}
+
+var _ Store = &ConfigMapStore{}
+
+func NewConfigMapStore(kubecli *kubernetes.Clientset, namespace, name string) *ConfigMapStore {
+	return &ConfigMapStore{
+		kubecli:   kubecli,
+		namespace: namespace,
+		name:      name,
+		key:       DefaultConfigMapKey,
+	}
+}
+
+func (s *ConfigMapStore) Load() (*State, error) {
+
+	data, err := kubeutils.GetConfigMapData(s.kubecli, s.namespace, s.name)
+	if err != nil {
+		return nil, err
+	}
+	return decodeState([]byte(data[s.key]))
+}
+
+func (s *ConfigMapStore) Update(fn func(state *State) error) error {
+
+	return kubeutils.UpdateConfigMapData(s.kubecli, s.namespace, s.name, func(data map[string]string) error {
+		state, err := decodeState([]byte(data[s.key]))
+		if err != nil {
+			return err
+		}
+		if err := fn(state); err != nil {
+			return err
+		}
+		encoded, err := encodeState(state)
+		if err != nil {
+			return err
+		}
+		data[s.key] = string(encoded)
+		return nil
+	})
+}
@@ -0,0 +1,114 @@
+//go:build !windows
+// +build !windows
+
+package ipam
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore persists the state as a local json file.
+// Concurrent processes are serialized by a flock on "<path>.lock".
+type FileStore struct {
+	path string
+}
+
+var _ Store = &FileStore{}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Load() (*State, error) {
+
+	unlock, err := s.lock(unix.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.read()
+}
+
+func (s *FileStore) Update(fn func(state *State) error) error {
+
+	unlock, err := s.lock(unix.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := s.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(state); err != nil {
+		return err
+	}
+	return s.write(state)
+}
+
+func (s *FileStore) lock(how int) (func(), error) {
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return nil, errors.Wrapf(err, "create directory for %s", s.path)
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open lock file for %s", s.path)
+	}
+	if err := unix.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "lock %s", s.path)
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (s *FileStore) read() (*State, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return newState(), nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", s.path)
+	}
+	return decodeState(data)
+}
+
+// write replaces the state file atomically, so a crash never leaves a truncated file behind
+func (s *FileStore) write(state *State) error {
+
+	data, err := encodeState(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "create temp file for %s", s.path)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "write %s", tmp.Name())
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "sync %s", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "close %s", tmp.Name())
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return errors.Wrapf(err, "rename %s", tmp.Name())
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package ipam
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+)
+
+// State is the persisted allocation state of a pool.
+// Subnets and IPs map an allocated cidr or ip to the owner it was handed out to.
+type State struct {
+	Subnets map[string]string `json:"subnets"`
+	IPs     map[string]string `json:"ips"`
+}
+
+// Store persists the State of an Allocator
+type Store interface {
+	// Load returns a snapshot of the current state
+	Load() (*State, error)
+
+	// Update loads the current state, applies fn and persists the result.
+	// Implementations must make the read-modify-write atomic against other writers,
+	// the state is left untouched if fn returns an error.
+	Update(fn func(state *State) error) error
+}
+
+func newState() *State {
+	return &State{
+		Subnets: make(map[string]string),
+		IPs:     make(map[string]string),
+	}
+}
+
+func decodeState(data []byte) (*State, error) {
+
+	state := newState()
+	if len(data) == 0 {
+		return state, nil
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrap(err, "decode ipam state")
+	}
+	if state.Subnets == nil {
+		state.Subnets = make(map[string]string)
+	}
+	if state.IPs == nil {
+		state.IPs = make(map[string]string)
+	}
+	return state, nil
+}
+
+func encodeState(state *State) ([]byte, error) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "encode ipam state")
+	}
+	return data, nil
+}
@@ -0,0 +1,212 @@
+package kmod
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+	"github.com/QQGoblin/go-sdk/pkg/fileutil"
+	"k8s.io/klog/v2"
+)
+
+// ModulesLoadDir holds the lists of modules systemd-modules-load loads at boot
+const ModulesLoadDir = "/etc/modules-load.d"
+
+// Module is a loaded kernel module as listed in /proc/modules
+type Module struct {
+	Name     string   `json:"name"`
+	Size     int      `json:"size"`
+	RefCount int      `json:"refCount"`
+	UsedBy   []string `json:"usedBy,omitempty"`
+	// State is "Live", "Loading" or "Unloading"
+	State string `json:"state"`
+}
+
+// Manager loads kernel modules and persists them to modules-load.d
+type Manager struct {
+	// Exec runs modprobe, nil runs it on the host
+	Exec executil.Executor
+	// Root is the filesystem modules-load.d is found in, ProcRoot and SysRoot are where proc and sysfs
+	// are mounted. Empty fields are "/", "/proc" and "/sys".
+	Root     string
+	ProcRoot string
+	SysRoot  string
+}
+
+// NewManager returns a Manager for the host running modprobe with exec
+func NewManager(exec executil.Executor) *Manager {
+	if exec == nil {
+		exec = executil.New()
+	}
+	return &Manager{Exec: exec, Root: "/", ProcRoot: "/proc", SysRoot: "/sys"}
+}
+
+func (m *Manager) executor() executil.Executor {
+	if m.Exec == nil {
+		return executil.New()
+	}
+	return m.Exec
+}
+
+// rootPath joins elem to root, or to def if root is empty
+func rootPath(root, def string, elem ...string) string {
+	if root == "" {
+		root = def
+	}
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// NormalizeName returns the module name as the kernel lists it, modprobe treats "-" and "_" alike
+func NormalizeName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// Loaded lists the modules in /proc/modules
+func (m *Manager) Loaded() ([]Module, error) {
+
+	data, err := ioutil.ReadFile(rootPath(m.ProcRoot, "/proc", "modules"))
+	if err != nil {
+		return nil, err
+	}
+
+	modules := make([]Module, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// "br_netfilter 32768 0 - Live 0x0000000000000000"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		module := Module{Name: fields[0], State: fields[4]}
+		module.Size, _ = strconv.Atoi(fields[1])
+		module.RefCount, _ = strconv.Atoi(fields[2])
+		if fields[3] != "-" {
+			for _, user := range strings.Split(strings.TrimSuffix(fields[3], ","), ",") {
+				if user != "" {
+					module.UsedBy = append(module.UsedBy, user)
+				}
+			}
+		}
+		modules = append(modules, module)
+	}
+	return modules, scanner.Err()
+}
+
+// IsLoaded returns true if the module is loaded or built into the kernel
+func (m *Manager) IsLoaded(name string) (bool, error) {
+
+	name = NormalizeName(name)
+	// kernels without module support have no /proc/modules, everything is builtin then
+	modules, err := m.Loaded()
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	for _, module := range modules {
+		if module.Name == name {
+			return true, nil
+		}
+	}
+	// builtin modules with parameters or a version show up in sysfs only
+	if _, err := os.Stat(rootPath(m.SysRoot, "/sys", "module", name)); err == nil {
+		return true, nil
+	}
+	return false, nil
+}
+
+// Load loads the module with modprobe unless it is loaded already, params are "key=value" options
+func (m *Manager) Load(name string, params ...string) error {
+	loaded, err := m.IsLoaded(name)
+	if err != nil {
+		return err
+	}
+	if loaded {
+		return nil
+	}
+	klog.V(2).Infof("load kernel module %s", name)
+	if _, err := m.executor().Run(nil, "modprobe", append([]string{name}, params...)...); err != nil {
+		return fmt.Errorf("failed to load kernel module %s: %v", name, err)
+	}
+	return nil
+}
+
+// Unload removes the module and the modules it depends on which are no longer used
+func (m *Manager) Unload(name string) error {
+	loaded, err := m.IsLoaded(name)
+	if err != nil || !loaded {
+		return err
+	}
+	if _, err := m.executor().Run(nil, "modprobe", "-r", name); err != nil {
+		return fmt.Errorf("failed to unload kernel module %s: %v", name, err)
+	}
+	return nil
+}
+
+// PersistPath returns the modules-load.d file for name, e.g. "kubernetes" -> kubernetes.conf
+func (m *Manager) PersistPath(name string) string {
+	return rootPath(m.Root, "/", ModulesLoadDir, name+".conf")
+}
+
+// Persist writes modules to modules-load.d/<name>.conf so they are loaded at boot, before the sysctl.d
+// files are applied. The file is only written if its content changed, which is returned.
+func (m *Manager) Persist(name string, modules ...string) (bool, error) {
+
+	sorted := append([]string{}, modules...)
+	sort.Strings(sorted)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# kernel modules %s\n", name)
+	for _, module := range sorted {
+		fmt.Fprintln(&b, module)
+	}
+	content := b.Bytes()
+
+	path := m.PersistPath(name)
+	current, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	if err := fileutil.WriteFileAtomic(path, content, 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Persisted returns the modules listed in modules-load.d/<name>.conf
+func (m *Manager) Persisted(name string) ([]string, error) {
+	data, err := ioutil.ReadFile(m.PersistPath(name))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	modules := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		modules = append(modules, line)
+	}
+	return modules, nil
+}
+
+// RemovePersisted deletes modules-load.d/<name>.conf, loaded modules stay loaded
+func (m *Manager) RemovePersisted(name string) error {
+	if err := os.Remove(m.PersistPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
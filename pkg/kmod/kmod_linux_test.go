@@ -0,0 +1,56 @@
+package kmod
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+)
+
+func TestManagerLoad(t *testing.T) {
+
+	procRoot := t.TempDir()
+	modules := "br_netfilter 32768 0 - Live 0x0000000000000000\n" +
+		"bridge 303104 1 br_netfilter, Live 0x0000000000000000\n"
+	if err := os.WriteFile(filepath.Join(procRoot, "modules"), []byte(modules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sysRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(sysRoot, "module", "nf_conntrack"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		module string
+		params []string
+		want   []string
+	}{
+		{name: "loaded", module: "br-netfilter", want: []string{}},
+		{name: "builtin", module: "nf_conntrack", want: []string{}},
+		{name: "not loaded", module: "ip_vs", params: []string{"conn_tab_bits=18"}, want: []string{"modprobe ip_vs conn_tab_bits=18"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := executil.NewFakeExecutor()
+			// Root is left empty, the zero value of the fields must be usable
+			m := &Manager{Exec: fake, ProcRoot: procRoot, SysRoot: sysRoot}
+			if err := m.Load(tt.module, tt.params...); err != nil {
+				t.Fatal(err)
+			}
+			got := fake.CommandLines()
+			if got == nil {
+				got = []string{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := (&Manager{}).PersistPath("kubernetes"), "/etc/modules-load.d/kubernetes.conf"; got != want {
+		t.Errorf("PersistPath() = %s, want %s", got, want)
+	}
+}
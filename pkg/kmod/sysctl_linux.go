@@ -0,0 +1,84 @@
+package kmod
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/sysctl"
+)
+
+// SysctlModules maps sysctl key prefixes to the modules providing the keys, a key only exists
+// once its module is loaded
+var SysctlModules = map[string][]string{
+	"net.bridge.bridge-nf-":        {"br_netfilter"},
+	"net.ipv4.vs.":                 {"ip_vs"},
+	"net.netfilter.nf_conntrack_":  {"nf_conntrack"},
+	"net.nf_conntrack_max":         {"nf_conntrack"},
+	"net.ipv4.netfilter.ip_conntr": {"nf_conntrack"},
+}
+
+// ModulesForSysctl returns the modules needed by the keys, sorted and without duplicates
+func ModulesForSysctl(keys ...string) []string {
+	seen := make(map[string]bool)
+	modules := make([]string, 0)
+	for _, key := range keys {
+		key = sysctl.NormalizeKey(key)
+		for prefix, mods := range SysctlModules {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			for _, module := range mods {
+				if !seen[module] {
+					seen[module] = true
+					modules = append(modules, module)
+				}
+			}
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// EnsureForSysctl loads the modules the keys depend on
+func (m *Manager) EnsureForSysctl(keys ...string) error {
+	for _, module := range ModulesForSysctl(keys...) {
+		if err := m.Load(module); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplySysctlProfile loads the modules the profile depends on together with the extra modules, persists
+// them to modules-load.d under the profile name so they are loaded before systemd-sysctl at boot, then
+// applies the profile with s. It returns the warnings of the sysctl transaction.
+func (m *Manager) ApplySysctlProfile(s *sysctl.Manager, p *sysctl.Profile, opts sysctl.ApplyOptions, extra ...string) ([]string, error) {
+
+	modules := ModulesForSysctl(p.Keys()...)
+	for _, module := range extra {
+		if !contains(modules, module) {
+			modules = append(modules, module)
+		}
+	}
+
+	for _, module := range modules {
+		if err := m.Load(module); err != nil {
+			return nil, err
+		}
+	}
+	if len(modules) > 0 {
+		if _, err := m.Persist(p.Name, modules...); err != nil {
+			return nil, err
+		}
+	}
+	return s.Apply(p, opts)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package kubeutils
+
+import (
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	kuberrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// GetConfigMapData returns the data of a configmap, or an empty map if the configmap does not exist
+func GetConfigMapData(kubecli *kubernetes.Clientset, namespace, name string) (map[string]string, error) {
+
+	cm, err := kubecli.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if kuberrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cm.Data == nil {
+		return map[string]string{}, nil
+	}
+	return cm.Data, nil
+}
+
+// UpdateConfigMapData read-modify-write the data of a configmap, the configmap is created if it does not exist.
+// update is called again with fresh data when the write conflicts with another writer.
+func UpdateConfigMapData(kubecli *kubernetes.Clientset, namespace, name string, update func(data map[string]string) error) error {
+
+	client := kubecli.CoreV1().ConfigMaps(namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := client.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil && !kuberrors.IsNotFound(err) {
+			return err
+		}
+
+		if kuberrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Data: map[string]string{},
+			}
+			if err := update(cm.Data); err != nil {
+				return err
+			}
+			_, err = client.Create(context.Background(), cm, metav1.CreateOptions{})
+			if kuberrors.IsAlreadyExists(err) {
+				// someone else created it in the meantime, retry as an update
+				return kuberrors.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		if err := update(cm.Data); err != nil {
+			return err
+		}
+		_, err = client.Update(context.Background(), cm, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -170,3 +170,11 @@ func ARPSendGratuitous(address, ifaceName string) error {
 	}
 	return sendARP(iface, m)
 }
+
+// ARPSendGratuitousInNetNS sends a gratuitous ARP message via the specified interface of the
+// network namespace at nsPath.
+func ARPSendGratuitousInNetNS(nsPath, address, ifaceName string) error {
+	return WithNetNS(nsPath, func() error {
+		return ARPSendGratuitous(address, ifaceName)
+	})
+}
@@ -0,0 +1,386 @@
+package network
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+)
+
+// ipRange is an inclusive range of addresses of a single family.
+type ipRange struct {
+	first *big.Int
+	last  *big.Int
+}
+
+// CIDRSet is a set of IPv4 and IPv6 addresses built from CIDRs. Each family is
+// stored as a sorted list of disjoint, non-adjacent ranges, so every operation
+// returns a canonical set regardless of how its operands were built.
+type CIDRSet struct {
+	v4 []ipRange
+	v6 []ipRange
+}
+
+// NewCIDRSet returns a set holding the union of the given networks.
+func NewCIDRSet(cidrs ...*net.IPNet) (*CIDRSet, error) {
+	s := &CIDRSet{}
+	for _, cidr := range cidrs {
+		if err := s.Insert(cidr); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ParseCIDRSet parses cidrs such as "10.244.0.0/16" or "fd00::/64" and returns
+// a set holding their union.
+func ParseCIDRSet(cidrs ...string) (*CIDRSet, error) {
+	s := &CIDRSet{}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %v", cidr, err)
+		}
+		if err := s.Insert(n); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Insert adds network to the set.
+func (s *CIDRSet) Insert(network *net.IPNet) error {
+	r, addrBits, err := rangeOf(network)
+	if err != nil {
+		return err
+	}
+	if addrBits == 8*net.IPv4len {
+		s.v4 = unionRanges(s.v4, []ipRange{r})
+	} else {
+		s.v6 = unionRanges(s.v6, []ipRange{r})
+	}
+	return nil
+}
+
+// IsEmpty returns true if the set holds no address.
+func (s *CIDRSet) IsEmpty() bool {
+	return len(s.v4) == 0 && len(s.v6) == 0
+}
+
+// Union returns a set holding every address in s or other.
+func (s *CIDRSet) Union(other *CIDRSet) *CIDRSet {
+	return &CIDRSet{
+		v4: unionRanges(s.v4, other.v4),
+		v6: unionRanges(s.v6, other.v6),
+	}
+}
+
+// Intersection returns a set holding every address in both s and other.
+func (s *CIDRSet) Intersection(other *CIDRSet) *CIDRSet {
+	return &CIDRSet{
+		v4: intersectRanges(s.v4, other.v4),
+		v6: intersectRanges(s.v6, other.v6),
+	}
+}
+
+// Difference returns a set holding every address in s but not in other.
+func (s *CIDRSet) Difference(other *CIDRSet) *CIDRSet {
+	return &CIDRSet{
+		v4: subtractRanges(s.v4, other.v4),
+		v6: subtractRanges(s.v6, other.v6),
+	}
+}
+
+// Contains returns true if ip is in the set.
+func (s *CIDRSet) Contains(ip net.IP) bool {
+	ranges, addrBits := s.family(ip)
+	n := ipToInt(ip, addrBits)
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].last.Cmp(n) >= 0 })
+	return i < len(ranges) && ranges[i].first.Cmp(n) <= 0
+}
+
+// ContainsCIDR returns true if every address of network is in the set.
+func (s *CIDRSet) ContainsCIDR(network *net.IPNet) bool {
+	other, err := NewCIDRSet(network)
+	if err != nil {
+		return false
+	}
+	return other.Difference(s).IsEmpty()
+}
+
+// Overlaps returns true if s and other have at least one address in common.
+func (s *CIDRSet) Overlaps(other *CIDRSet) bool {
+	return !s.Intersection(other).IsEmpty()
+}
+
+// CIDRs aggregates the set into the minimal list of prefixes covering exactly
+// its addresses, IPv4 first, each family in ascending order.
+func (s *CIDRSet) CIDRs() []*net.IPNet {
+	cidrs := make([]*net.IPNet, 0)
+	for _, r := range s.v4 {
+		cidrs = append(cidrs, rangeToCIDRs(r, 8*net.IPv4len)...)
+	}
+	for _, r := range s.v6 {
+		cidrs = append(cidrs, rangeToCIDRs(r, 8*net.IPv6len)...)
+	}
+	return cidrs
+}
+
+// String returns the aggregated prefixes of the set, comma separated.
+func (s *CIDRSet) String() string {
+	out := ""
+	for i, cidr := range s.CIDRs() {
+		if i > 0 {
+			out += ","
+		}
+		out += cidr.String()
+	}
+	return out
+}
+
+// NextFree returns the lowest /prefixLen network within pool that has no
+// address in common with the set, so callers can allocate a free range by
+// passing the set of ranges already in use.
+func (s *CIDRSet) NextFree(pool *net.IPNet, prefixLen int) (*net.IPNet, error) {
+	start, maskBits, addrBits, err := normalizeIPNet(pool)
+	if err != nil {
+		return nil, err
+	}
+	if prefixLen < maskBits || prefixLen > addrBits {
+		return nil, fmt.Errorf("cannot allocate /%d from %s", prefixLen, pool)
+	}
+
+	ranges := s.ranges(addrBits)
+	first := ipToInt(start, addrBits)
+	last := lastAddress(first, addrBits-maskBits)
+	size := new(big.Int).Lsh(big.NewInt(1), uint(addrBits-prefixLen))
+
+	candidate := first
+	for _, r := range ranges {
+		candidateLast := new(big.Int).Add(candidate, size)
+		candidateLast.Sub(candidateLast, big.NewInt(1))
+		if candidateLast.Cmp(last) > 0 {
+			break
+		}
+		if r.last.Cmp(candidate) < 0 {
+			continue
+		}
+		if r.first.Cmp(candidateLast) > 0 {
+			break
+		}
+		// candidate overlaps r: move to the first aligned block after r
+		next := new(big.Int).Add(r.last, big.NewInt(1))
+		candidate = alignUp(next, size)
+	}
+
+	candidateLast := new(big.Int).Add(candidate, size)
+	candidateLast.Sub(candidateLast, big.NewInt(1))
+	if candidateLast.Cmp(last) > 0 {
+		return nil, fmt.Errorf("no free /%d left in %s", prefixLen, pool)
+	}
+	return &net.IPNet{
+		IP:   intToIP(candidate, addrBits),
+		Mask: net.CIDRMask(prefixLen, addrBits),
+	}, nil
+}
+
+// family returns the ranges of the family ip belongs to and its address width.
+func (s *CIDRSet) family(ip net.IP) ([]ipRange, int) {
+	if ip.To4() != nil {
+		return s.v4, 8 * net.IPv4len
+	}
+	return s.v6, 8 * net.IPv6len
+}
+
+// ranges returns the ranges of networks addrBits wide, which is how Insert
+// files them.
+func (s *CIDRSet) ranges(addrBits int) []ipRange {
+	if addrBits == 8*net.IPv4len {
+		return s.v4
+	}
+	return s.v6
+}
+
+// CIDROverlap reports two named networks that have addresses in common.
+type CIDROverlap struct {
+	Name      string
+	OtherName string
+	Overlap   []*net.IPNet
+}
+
+func (o CIDROverlap) String() string {
+	return fmt.Sprintf("%s overlaps with %s on %v", o.Name, o.OtherName, o.Overlap)
+}
+
+// FindOverlaps compares every pair of the named networks, such as pod cidr,
+// service cidr, node networks and vip, and reports those which overlap. The
+// result is ordered by name.
+func FindOverlaps(cidrs map[string]*net.IPNet) ([]CIDROverlap, error) {
+	names := make([]string, 0, len(cidrs))
+	sets := make(map[string]*CIDRSet, len(cidrs))
+	for name, cidr := range cidrs {
+		s, err := NewCIDRSet(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s: %v", name, err)
+		}
+		names = append(names, name)
+		sets[name] = s
+	}
+	sort.Strings(names)
+
+	overlaps := make([]CIDROverlap, 0)
+	for i := range names {
+		for j := i + 1; j < len(names); j++ {
+			common := sets[names[i]].Intersection(sets[names[j]])
+			if common.IsEmpty() {
+				continue
+			}
+			overlaps = append(overlaps, CIDROverlap{
+				Name:      names[i],
+				OtherName: names[j],
+				Overlap:   common.CIDRs(),
+			})
+		}
+	}
+	return overlaps, nil
+}
+
+// ValidateNoOverlap returns an error describing every overlap between the
+// named networks, or nil if they are pairwise disjoint.
+func ValidateNoOverlap(cidrs map[string]*net.IPNet) error {
+	overlaps, err := FindOverlaps(cidrs)
+	if err != nil {
+		return err
+	}
+	if len(overlaps) == 0 {
+		return nil
+	}
+	return fmt.Errorf("networks overlap: %v", overlaps)
+}
+
+// range <-> prefix transforms
+
+// rangeOf returns the address range covered by network and its address width.
+func rangeOf(network *net.IPNet) (ipRange, int, error) {
+	start, maskBits, addrBits, err := normalizeIPNet(network)
+	if err != nil {
+		return ipRange{}, 0, err
+	}
+	first := ipToInt(start, addrBits)
+	return ipRange{first: first, last: lastAddress(first, addrBits-maskBits)}, addrBits, nil
+}
+
+// lastAddress returns the last address of the block of 2^hostBits addresses
+// starting at first.
+func lastAddress(first *big.Int, hostBits int) *big.Int {
+	last := new(big.Int).Lsh(big.NewInt(1), uint(hostBits))
+	last.Add(last, first)
+	return last.Sub(last, big.NewInt(1))
+}
+
+// alignUp rounds n up to the next multiple of size, which is a power of 2.
+func alignUp(n, size *big.Int) *big.Int {
+	mask := new(big.Int).Sub(size, big.NewInt(1))
+	aligned := new(big.Int).Add(n, mask)
+	return aligned.AndNot(aligned, mask)
+}
+
+// rangeToCIDRs splits r into the minimal list of prefixes covering it.
+func rangeToCIDRs(r ipRange, addrBits int) []*net.IPNet {
+	cidrs := make([]*net.IPNet, 0)
+	first := new(big.Int).Set(r.first)
+	for first.Cmp(r.last) <= 0 {
+		// largest block aligned on first, limited by the trailing zero bits
+		hostBits := int(first.TrailingZeroBits())
+		if first.Sign() == 0 || hostBits > addrBits {
+			hostBits = addrBits
+		}
+		// shrink the block until it fits into the range
+		for hostBits > 0 && lastAddress(first, hostBits).Cmp(r.last) > 0 {
+			hostBits--
+		}
+		cidrs = append(cidrs, &net.IPNet{
+			IP:   intToIP(first, addrBits),
+			Mask: net.CIDRMask(addrBits-hostBits, addrBits),
+		})
+		first = lastAddress(first, hostBits)
+		first.Add(first, big.NewInt(1))
+	}
+	return cidrs
+}
+
+// range list algebra, all inputs and outputs are sorted and disjoint
+
+func unionRanges(a, b []ipRange) []ipRange {
+	all := make([]ipRange, 0, len(a)+len(b))
+	all = append(all, a...)
+	all = append(all, b...)
+	sort.Slice(all, func(i, j int) bool { return all[i].first.Cmp(all[j].first) < 0 })
+
+	merged := make([]ipRange, 0, len(all))
+	for _, r := range all {
+		if n := len(merged); n > 0 {
+			// merge overlapping and adjacent ranges
+			next := new(big.Int).Add(merged[n-1].last, big.NewInt(1))
+			if r.first.Cmp(next) <= 0 {
+				if r.last.Cmp(merged[n-1].last) > 0 {
+					merged[n-1].last = r.last
+				}
+				continue
+			}
+		}
+		merged = append(merged, ipRange{first: r.first, last: r.last})
+	}
+	return merged
+}
+
+func intersectRanges(a, b []ipRange) []ipRange {
+	out := make([]ipRange, 0)
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		first, last := maxInt(a[i].first, b[j].first), minInt(a[i].last, b[j].last)
+		if first.Cmp(last) <= 0 {
+			out = append(out, ipRange{first: first, last: last})
+		}
+		if a[i].last.Cmp(b[j].last) < 0 {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+func subtractRanges(a, b []ipRange) []ipRange {
+	out := make([]ipRange, 0)
+	j := 0
+	for _, r := range a {
+		first := r.first
+		for j < len(b) && b[j].last.Cmp(first) < 0 {
+			j++
+		}
+		for k := j; k < len(b) && b[k].first.Cmp(r.last) <= 0; k++ {
+			if b[k].first.Cmp(first) > 0 {
+				out = append(out, ipRange{first: first, last: new(big.Int).Sub(b[k].first, big.NewInt(1))})
+			}
+			first = new(big.Int).Add(b[k].last, big.NewInt(1))
+		}
+		if first.Cmp(r.last) <= 0 {
+			out = append(out, ipRange{first: first, last: r.last})
+		}
+	}
+	return out
+}
+
+func maxInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) > 0 {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b *big.Int) *big.Int {
+	if a.Cmp(b) < 0 {
+		return a
+	}
+	return b
+}
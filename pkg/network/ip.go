@@ -14,8 +14,22 @@ import (
 // Get local Address from local route table.
 // Copy from kube-proxy src.
 func GetLocalAddresses(dev string, filterDevs ...string) (sets.String, error) {
+	return getLocalAddresses(&netlink.Handle{}, dev, filterDevs...)
+}
+
+// GetLocalAddressesInNetNS works like GetLocalAddresses in the network namespace at nsPath.
+func GetLocalAddressesInNetNS(nsPath, dev string, filterDevs ...string) (sets.String, error) {
+	h, err := NetlinkHandle(nsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Delete()
+
+	return getLocalAddresses(h, dev, filterDevs...)
+}
+
+func getLocalAddresses(h *netlink.Handle, dev string, filterDevs ...string) (sets.String, error) {
 
-	h := netlink.Handle{}
 	chosenLinkIndex := -1
 	if dev != "" {
 		link, err := h.LinkByName(dev)
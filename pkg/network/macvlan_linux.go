@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"math/rand"
 	"net"
 )
 
@@ -43,3 +45,55 @@ func CreateMacvlanDevice(name, master, mac string) (netlink.Link, error) {
 
 	return macvlan, nil
 }
+
+// CreateMacvlanDeviceInNetNS creates a macvlan device on master, which lives in the current network
+// namespace, and moves it into the network namespace at nsPath under the given name.
+func CreateMacvlanDeviceInNetNS(nsPath, name, master, mac string) (netlink.Link, error) {
+
+	if nsPath == "" {
+		return CreateMacvlanDevice(name, master, mac)
+	}
+
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netns %s: %v", nsPath, err)
+	}
+	defer ns.Close()
+
+	// create the device under a temporary name, so it does not clash with devices of the current namespace
+	tmpName := fmt.Sprintf("mv%08x", rand.Uint32())
+	link, err := CreateMacvlanDevice(tmpName, master, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := netlink.LinkSetNsFd(link, int(ns)); err != nil {
+		_ = netlink.LinkDel(link)
+		return nil, fmt.Errorf("failed to move macvlan to netns %s: %v", nsPath, err)
+	}
+
+	h, err := NetlinkHandle(nsPath)
+	if err != nil {
+		_ = WithNetNS(nsPath, func() error { return netlink.LinkDel(link) })
+		return nil, err
+	}
+	defer h.Delete()
+
+	moved, err := h.LinkByName(tmpName)
+	if err != nil {
+		// the device keeps its index unless it is taken in the namespace, delete it by index
+		_ = h.LinkDel(link)
+		return nil, errors.Wrapf(err, "macvlan<%s> link not found in netns %s: %v", tmpName, nsPath, err)
+	}
+	if err := h.LinkSetName(moved, name); err != nil {
+		_ = h.LinkDel(moved)
+		return nil, fmt.Errorf("failed to rename macvlan to %s: %v", name, err)
+	}
+
+	macvlan, err := h.LinkByName(name)
+	if err != nil {
+		_ = h.LinkDel(moved)
+		return nil, errors.Wrapf(err, "macvlan<%s> link not found in netns %s: %v", name, nsPath, err)
+	}
+	return macvlan, nil
+}
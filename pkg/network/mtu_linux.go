@@ -0,0 +1,253 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	DefaultMTUProbeTimeout = 1 * time.Second
+
+	// smallest mtu every link has to support
+	minIPv4MTU = 576
+	minIPv6MTU = 1280
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	icmpHeaderLen = 8
+)
+
+// ProbePathMTU finds the path mtu to address by sending icmp echo requests with the don't fragment
+// bit set, bisecting between the minimum mtu of the family and maxMTU. A maxMTU of 0 uses the mtu of
+// the outgoing interface. Each probe waits at most timeout for its reply. Raw sockets require
+// CAP_NET_RAW. address is an ip or a host name, which is resolved to its first address.
+func ProbePathMTU(ctx context.Context, address string, maxMTU int, timeout time.Duration) (int, error) {
+
+	ip, err := resolveIP(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	if timeout <= 0 {
+		timeout = DefaultMTUProbeTimeout
+	}
+	if maxMTU <= 0 {
+		mtu, err := routeMTU(ip)
+		if err != nil {
+			return 0, err
+		}
+		maxMTU = mtu
+	}
+
+	p, err := newMTUProber(ip, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer p.close()
+
+	lo := minIPv4MTU
+	if p.v6 {
+		lo = minIPv6MTU
+	}
+	if maxMTU < lo {
+		return 0, fmt.Errorf("mtu %d is below the minimum of %d", maxMTU, lo)
+	}
+
+	ok, err := p.probe(lo)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%s does not answer icmp echo requests", address)
+	}
+
+	// invariant: lo passes, everything above hi fails
+	hi := maxMTU
+	for lo < hi {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		mid := (lo + hi + 1) / 2
+		ok, err := p.probe(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, nil
+}
+
+// routeMTU returns the mtu of the interface packets to ip leave through
+func routeMTU(ip net.IP) (int, error) {
+	routes, err := netlink.RouteGet(ip)
+	if err != nil || len(routes) == 0 {
+		return 0, fmt.Errorf("failed to get route to %s: %v", ip, err)
+	}
+	if routes[0].MTU > 0 {
+		return routes[0].MTU, nil
+	}
+	link, err := netlink.LinkByIndex(routes[0].LinkIndex)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get link of route to %s: %v", ip, err)
+	}
+	return link.Attrs().MTU, nil
+}
+
+type mtuProber struct {
+	fd      int
+	v6      bool
+	id      int
+	seq     int
+	timeout time.Duration
+}
+
+func newMTUProber(ip net.IP, timeout time.Duration) (*mtuProber, error) {
+
+	p := &mtuProber{
+		id:      os.Getpid() & 0xffff,
+		timeout: timeout,
+	}
+
+	var (
+		fd  int
+		err error
+		sa  unix.Sockaddr
+	)
+	if ip4 := ip.To4(); ip4 != nil {
+		fd, err = unix.Socket(unix.AF_INET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMP)
+		if err == nil {
+			// set the don't fragment bit, ignoring the path mtu cached by the kernel
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		}
+		addr := &unix.SockaddrInet4{}
+		copy(addr.Addr[:], ip4)
+		sa = addr
+	} else {
+		p.v6 = true
+		fd, err = unix.Socket(unix.AF_INET6, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_ICMPV6)
+		if err == nil {
+			err = unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE)
+		}
+		addr := &unix.SockaddrInet6{}
+		copy(addr.Addr[:], ip.To16())
+		sa = addr
+	}
+	if err != nil {
+		if fd > 0 {
+			unix.Close(fd)
+		}
+		return nil, fmt.Errorf("failed to open icmp socket: %v", err)
+	}
+	p.fd = fd
+
+	if err := unix.Connect(fd, sa); err != nil {
+		p.close()
+		return nil, fmt.Errorf("failed to connect icmp socket to %s: %v", ip, err)
+	}
+	return p, nil
+}
+
+func (p *mtuProber) close() {
+	unix.Close(p.fd)
+}
+
+// probe sends an echo request of mtu bytes including headers and returns true if it was answered
+func (p *mtuProber) probe(mtu int) (bool, error) {
+
+	headerLen, proto := ipv4HeaderLen+icmpHeaderLen, 1
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if p.v6 {
+		headerLen, proto = ipv6HeaderLen+icmpHeaderLen, 58
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+
+	p.seq = (p.seq + 1) & 0xffff
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: p.id, Seq: p.seq, Data: make([]byte, mtu-headerLen)},
+	}
+	// the kernel computes the icmpv6 checksum for raw sockets
+	packet, err := msg.Marshal(nil)
+	if err != nil {
+		return false, err
+	}
+
+	if err := unix.Send(p.fd, packet, 0); err != nil {
+		if err == unix.EMSGSIZE {
+			// larger than the mtu of the outgoing interface
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to send icmp echo request: %v", err)
+	}
+
+	deadline := time.Now().Add(p.timeout)
+	buf := make([]byte, mtu+ipv6HeaderLen)
+	for {
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return false, nil
+		}
+		tv := unix.NsecToTimeval(remain.Nanoseconds())
+		if err := unix.SetsockoptTimeval(p.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return false, err
+		}
+		n, err := unix.Read(p.fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err == unix.EMSGSIZE {
+			// a router on the path reported fragmentation needed
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read icmp reply: %v", err)
+		}
+
+		data := buf[:n]
+		if !p.v6 && n > 0 {
+			// raw ipv4 sockets receive the ip header
+			ihl := int(data[0]&0x0f) * 4
+			if ihl > n {
+				continue
+			}
+			data = data[ihl:]
+		}
+		reply, err := icmp.ParseMessage(proto, data)
+		if err != nil {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if !ok || echo.ID != p.id || echo.Seq != p.seq {
+			continue
+		}
+		if reply.Type == ipv4.ICMPTypeEchoReply || reply.Type == ipv6.ICMPTypeEchoReply {
+			return true, nil
+		}
+	}
+}
+
+// resolveIP returns address if it is an ip, otherwise the first address the host name resolves to
+func resolveIP(ctx context.Context, address string) (net.IP, error) {
+	if ip := net.ParseIP(address); ip != nil {
+		return ip, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", address, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s has no address", address)
+	}
+	return addrs[0].IP, nil
+}
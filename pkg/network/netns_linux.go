@@ -0,0 +1,117 @@
+package network
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	// NetNSRunDir is where `ip netns` keeps the bind mounts of named network namespaces
+	NetNSRunDir = "/var/run/netns"
+)
+
+// NetNSPath returns the path of a named network namespace, as created by `ip netns add`
+func NetNSPath(name string) string {
+	return filepath.Join(NetNSRunDir, name)
+}
+
+// CreateNamedNetNS creates a network namespace bind mounted at NetNSPath(name).
+// The calling goroutine stays in its original namespace.
+func CreateNamedNetNS(name string) error {
+
+	// netns.NewNamed switches the calling thread into the new namespace
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get current netns: %v", err)
+	}
+	defer origin.Close()
+
+	ns, err := netns.NewNamed(name)
+	if restoreErr := netns.Set(origin); restoreErr != nil {
+		// the thread is stuck in the wrong namespace, do not hand it back to the scheduler
+		runtime.LockOSThread()
+		return fmt.Errorf("failed to restore netns: %v", restoreErr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create netns %s: %v", name, err)
+	}
+	return ns.Close()
+}
+
+// DeleteNamedNetNS deletes a network namespace created by CreateNamedNetNS or `ip netns add`
+func DeleteNamedNetNS(name string) error {
+	if err := netns.DeleteNamed(name); err != nil {
+		return fmt.Errorf("failed to delete netns %s: %v", name, err)
+	}
+	return nil
+}
+
+// WithNetNS runs fn with the calling OS thread switched into the network namespace at nsPath,
+// such as NetNSPath(name) or /proc/<pid>/ns/net. An empty nsPath runs fn in the current namespace.
+// fn must not start goroutines which expect to run in the namespace.
+func WithNetNS(nsPath string, fn func() error) (err error) {
+
+	if nsPath == "" {
+		return fn()
+	}
+
+	target, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %s: %v", nsPath, err)
+	}
+	defer target.Close()
+
+	runtime.LockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to get current netns: %v", err)
+	}
+	defer origin.Close()
+
+	if err := netns.Set(target); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to enter netns %s: %v", nsPath, err)
+	}
+
+	// restore in a defer, so a panic recovered by the caller does not leave the goroutine in the namespace
+	defer func() {
+		if restoreErr := netns.Set(origin); restoreErr != nil {
+			// keep the thread locked, so it is terminated with the goroutine instead of being reused
+			err = fmt.Errorf("failed to restore netns: %v", restoreErr)
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+
+	return fn()
+}
+
+// NetlinkHandle returns a netlink handle operating in the network namespace at nsPath,
+// an empty nsPath returns a handle for the current namespace. The caller releases it with Delete.
+func NetlinkHandle(nsPath string) (*netlink.Handle, error) {
+
+	if nsPath == "" {
+		return netlink.NewHandle()
+	}
+
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netns %s: %v", nsPath, err)
+	}
+	defer ns.Close()
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create netlink handle in %s", nsPath)
+	}
+	return h, nil
+}
@@ -0,0 +1,199 @@
+package network
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+
+	// socket states in /proc/net/{tcp,udp}, see include/net/tcp_states.h
+	tcpStateListen      = "0A"
+	udpStateUnconnected = "07"
+)
+
+// ProcRoot is where the proc filesystem is read from, tests may point it to a fixture directory
+var ProcRoot = "/proc"
+
+// PortStatus reports whether a local port is taken, and by which process
+type PortStatus struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	InUse    bool   `json:"inUse"`
+	// Address is the local address the port is bound to
+	Address string `json:"address,omitempty"`
+	// PID and Process identify the owner, they are empty if the socket belongs to another pid namespace
+	PID     int    `json:"pid,omitempty"`
+	Process string `json:"process,omitempty"`
+}
+
+func (s PortStatus) String() string {
+	if !s.InUse {
+		return fmt.Sprintf("%s/%d is free", s.Protocol, s.Port)
+	}
+	if s.PID == 0 {
+		return fmt.Sprintf("%s/%d is in use on %s", s.Protocol, s.Port, s.Address)
+	}
+	return fmt.Sprintf("%s/%d is in use on %s by %s(%d)", s.Protocol, s.Port, s.Address, s.Process, s.PID)
+}
+
+type procSocket struct {
+	address net.IP
+	port    int
+	inode   string
+}
+
+// CheckLocalPorts reports for each port whether a socket of protocol ("tcp" or "udp") is bound to it,
+// tcp sockets only count while listening. Sockets are read from /proc/net, so ports taken in other
+// network namespaces are not reported.
+func CheckLocalPorts(protocol string, ports ...int) ([]PortStatus, error) {
+
+	var files []string
+	state := ""
+	switch protocol {
+	case ProtocolTCP:
+		files = []string{"net/tcp", "net/tcp6"}
+		state = tcpStateListen
+	case ProtocolUDP:
+		files = []string{"net/udp", "net/udp6"}
+		state = udpStateUnconnected
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q", protocol)
+	}
+
+	sockets := make([]procSocket, 0)
+	for _, f := range files {
+		found, err := readProcSockets(filepath.Join(ProcRoot, f), state)
+		if os.IsNotExist(err) {
+			// kernel without ipv6
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, found...)
+	}
+
+	var owners map[string]int
+	statuses := make([]PortStatus, 0, len(ports))
+	for _, port := range ports {
+		status := PortStatus{Protocol: protocol, Port: port}
+		for _, s := range sockets {
+			if s.port != port {
+				continue
+			}
+			if owners == nil {
+				owners = socketOwners()
+			}
+			status.InUse = true
+			status.Address = s.address.String()
+			if pid, ok := owners[s.inode]; ok {
+				status.PID = pid
+				status.Process = processName(pid)
+			}
+			break
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// readProcSockets parses a /proc/net/{tcp,udp}[6] table and returns the sockets in the given state
+func readProcSockets(path, state string) ([]procSocket, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sockets := make([]procSocket, 0)
+	scanner := bufio.NewScanner(f)
+	// skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+		ip, port, err := parseProcAddress(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		sockets = append(sockets, procSocket{address: ip, port: port, inode: fields[9]})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcAddress parses "0100007F:1F90", the address is stored as native endian 32 bit words
+func parseProcAddress(s string) (net.IP, int, error) {
+
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port %q", s)
+	}
+
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return ip, int(port), nil
+}
+
+// socketOwners maps socket inodes to the pid holding them, by scanning /proc/<pid>/fd
+func socketOwners() map[string]int {
+
+	owners := make(map[string]int)
+	procs, err := ioutil.ReadDir(ProcRoot)
+	if err != nil {
+		return owners
+	}
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(ProcRoot, p.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// process exited or is not accessible
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode := strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = pid
+			}
+		}
+	}
+	return owners
+}
+
+func processName(pid int) string {
+	comm, err := ioutil.ReadFile(filepath.Join(ProcRoot, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
@@ -0,0 +1,77 @@
+package network
+
+import (
+	"context"
+	"github.com/QQGoblin/go-sdk/pkg/concurrency"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultDialTimeout     = 3 * time.Second
+	DefaultDialConcurrency = 16
+)
+
+// DialResult reports whether a tcp address accepted a connection
+type DialResult struct {
+	Address   string        `json:"address"`
+	Reachable bool          `json:"reachable"`
+	Latency   time.Duration `json:"latency,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// CheckReachability dials every "host:port" address over tcp, at most parallelism at a time, each
+// attempt bounded by timeout. Results are ordered like addresses.
+func CheckReachability(ctx context.Context, addresses []string, timeout time.Duration, parallelism int) []DialResult {
+
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	if parallelism <= 0 {
+		parallelism = DefaultDialConcurrency
+	}
+
+	results := make([]DialResult, len(addresses))
+	wg := concurrency.NewWaitGroup(parallelism)
+	for i := range addresses {
+		wg.BlockAdd()
+		go func(i int) {
+			defer wg.Done()
+			results[i] = dial(ctx, addresses[i], timeout)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// CheckNodesReachability dials port on every node, results are ordered by node address
+func CheckNodesReachability(ctx context.Context, nodes []string, port string, timeout time.Duration, parallelism int) []DialResult {
+
+	addresses := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		addresses = append(addresses, net.JoinHostPort(node, port))
+	}
+	sort.Strings(addresses)
+	return CheckReachability(ctx, addresses, timeout, parallelism)
+}
+
+func dial(ctx context.Context, address string, timeout time.Duration) DialResult {
+
+	result := DialResult{Address: address}
+
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	conn.Close()
+
+	result.Reachable = true
+	result.Latency = time.Since(start)
+	return result
+}
@@ -2,23 +2,22 @@ package network
 
 import (
 	"fmt"
-	"math"
+	"math/big"
+	"math/bits"
 	"net"
 )
 
+// maxSubnetShift limits how many subnets SubnetShift materializes at once.
+const maxSubnetShift = 31
+
 // SubnetInto wraps SubnetShift and divides a network into at least count-many,
 // equal-sized subnets, which are as large as allowed.
 func SubnetInto(network *net.IPNet, count int) ([]*net.IPNet, error) {
-	maskBits, _ := network.Mask.Size()
-	hostBits := 32 - maskBits
-	hostCount := 1 << uint(hostBits)
-
-	// divide hosts among subnets
-	ideal := float64(hostCount) / float64(count)
-	// largest power of 2, not exceeding the ideal (float64 to int conversion
-	// truncates toward zero)
-	newHostBits := int(math.Log2(ideal))
-	shift := hostBits - newHostBits
+	if count <= 0 {
+		return nil, fmt.Errorf("subnet count must be positive, got %d", count)
+	}
+	// smallest power of 2, not less than count
+	shift := bits.Len(uint(count - 1))
 	return SubnetShift(network, shift)
 }
 
@@ -27,83 +26,135 @@ func SubnetShift(network *net.IPNet, bits int) ([]*net.IPNet, error) {
 	if bits < 0 {
 		return nil, fmt.Errorf("bit shift may not be negative, got %d", bits)
 	}
-	if bits > 31 {
+	if bits > maxSubnetShift {
 		return nil, fmt.Errorf("network subnets cannot be divided %d times", bits)
 	}
-	// network divides into 2^bits subnets
-	subnetCount := 1 << uint(bits)
-	subnets := make([]*net.IPNet, subnetCount)
 
 	// network info
-	start := network.IP
-	maskBits, _ := network.Mask.Size()
-	hostBits := 32 - maskBits
-
-	if maskBits+bits > 32 {
-		return nil, fmt.Errorf("network subnet mask greater than /32, /%d is invalid", maskBits+bits)
+	start, maskBits, addrBits, err := normalizeIPNet(network)
+	if err != nil {
+		return nil, err
+	}
+	if maskBits+bits > addrBits {
+		return nil, fmt.Errorf("network subnet mask greater than /%d, /%d is invalid", addrBits, maskBits+bits)
 	}
 
 	// divide network into subnets
 	newMaskBits := maskBits + bits
-	newHostBits := hostBits - bits
+	newHostBits := addrBits - newMaskBits
 	// subnet bitmasks are shifted by 'bits' places
-	newMask := net.CIDRMask(newMaskBits, 32)
+	newMask := net.CIDRMask(newMaskBits, addrBits)
 
-	// hosts per subnet
-	hostCount := 1 << uint(newHostBits)
+	// network divides into 2^bits subnets, each holding 2^newHostBits hosts
+	subnetCount := 1 << uint(bits)
+	subnets := make([]*net.IPNet, subnetCount)
+	hostCount := new(big.Int).Lsh(big.NewInt(1), uint(newHostBits))
 
+	ip := ipToInt(start, addrBits)
 	for i := 0; i < subnetCount; i++ {
-		ip := numeric(start) + uint32(i*hostCount)
 		subnets[i] = &net.IPNet{
-			IP:   bytewise(ip),
+			IP:   intToIP(ip, addrBits),
 			Mask: newMask,
 		}
+		ip = new(big.Int).Add(ip, hostCount)
 	}
 
 	return subnets, nil
 }
 
+// normalizeIPNet returns the masked network address together with the prefix
+// length and the address width (32 for IPv4, 128 for IPv6) of network.
+func normalizeIPNet(network *net.IPNet) (net.IP, int, int, error) {
+	if network == nil {
+		return nil, 0, 0, fmt.Errorf("network may not be nil")
+	}
+	maskBits, addrBits := network.Mask.Size()
+	if addrBits == 0 {
+		return nil, 0, 0, fmt.Errorf("network %s has a non-canonical mask", network)
+	}
+
+	ip := network.IP
+	if addrBits == 8*net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+	if ip == nil {
+		return nil, 0, 0, fmt.Errorf("network %s has a mismatched address and mask", network)
+	}
+	return ip.Mask(network.Mask), maskBits, addrBits, nil
+}
+
 // IP <-> integer transforms
 
-// numeric returns a uint32 numeric representation of a net.IP.
-func numeric(bytes net.IP) uint32 {
-	var ip uint32
-	// most significant to least significant
-	for i, b := range []byte(bytes) {
-		// bitwise or ("append" in this case)
-		ip |= uint32(b) << (8 * uint32(3-i))
+// ipToInt returns a big.Int numeric representation of a net.IP, addrBits wide.
+// IPv4-mapped IPv6 addresses keep their 16-byte form in 128-bit networks.
+func ipToInt(ip net.IP, addrBits int) *big.Int {
+	if addrBits == 8*net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
 	}
-	return ip
+	return new(big.Int).SetBytes(ip)
 }
 
-// bytewise returns a net.IP byte slice alias representation of an uint32.
-// Note that not all uint32 values are valid IP addresses.
-func bytewise(numeric uint32) net.IP {
-	ip := make([]byte, 4)
-	// least significant to most significant
-	for i := 3; i >= 0; i-- {
-		// AND away all but least significant
-		ip[i] = byte(numeric & 0xFF)
-		// nuke least significant byte
-		numeric >>= 8
+// intToIP returns the net.IP representation of numeric, addrBits wide.
+// Note that values wider than addrBits are truncated to the low-order bits.
+func intToIP(numeric *big.Int, addrBits int) net.IP {
+	size := addrBits / 8
+	b := numeric.Bytes()
+	ip := make(net.IP, size)
+	if len(b) > size {
+		b = b[len(b)-size:]
 	}
-	return net.IP(ip)
+	copy(ip[size-len(b):], b)
+	return ip
 }
 
-// SubnetAllIPs return all ip address in this subnet
-func SubnetAllIPs(network *net.IPNet) []*net.IP {
+// IPIterator walks every address of a network in ascending order.
+type IPIterator struct {
+	next *big.Int
+	last *big.Int
+	bits int
+}
 
-	ips := make([]*net.IP, 0)
-	ipInNumeric := numeric(network.IP.Mask(network.Mask))
+// Next returns the next address of the network, or nil once all addresses
+// have been returned.
+func (it *IPIterator) Next() net.IP {
+	if it.next == nil || it.next.Cmp(it.last) > 0 {
+		return nil
+	}
+	ip := intToIP(it.next, it.bits)
+	it.next = new(big.Int).Add(it.next, big.NewInt(1))
+	return ip
+}
 
-	for {
-		ip := bytewise(ipInNumeric)
-		if network.Contains(ip) {
-			ips = append(ips, &ip)
-		} else {
-			break
-		}
-		ipInNumeric++
+// Count returns the number of addresses not yet returned by Next.
+func (it *IPIterator) Count() *big.Int {
+	if it.next == nil || it.next.Cmp(it.last) > 0 {
+		return new(big.Int)
+	}
+	remain := new(big.Int).Sub(it.last, it.next)
+	return remain.Add(remain, big.NewInt(1))
+}
+
+// SubnetAllIPs return an iterator over all ip address in this subnet. Addresses
+// are produced lazily, so iterating a large IPv4 or IPv6 network never holds
+// more than one address at a time.
+func SubnetAllIPs(network *net.IPNet) (*IPIterator, error) {
+	start, maskBits, addrBits, err := normalizeIPNet(network)
+	if err != nil {
+		return nil, err
 	}
-	return ips
+
+	first := ipToInt(start, addrBits)
+	size := new(big.Int).Lsh(big.NewInt(1), uint(addrBits-maskBits))
+	last := new(big.Int).Add(first, size)
+	last.Sub(last, big.NewInt(1))
+
+	return &IPIterator{
+		next: first,
+		last: last,
+		bits: addrBits,
+	}, nil
 }
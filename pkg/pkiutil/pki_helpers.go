@@ -378,6 +378,37 @@ func TryLoadPrivatePublicKeyFromDisk(pkiPath, name string) (*rsa.PrivateKey, *rs
 	return k, p, nil
 }
 
+// TryLoadPublicKeyFromDisk tries to load the public key <name>.pub from the disk, RSA and ECDSA keys are allowed
+func TryLoadPublicKeyFromDisk(pkiPath, name string) (crypto.PublicKey, error) {
+	publicKeyPath := pathForPublicKey(pkiPath, name)
+
+	pubKeys, err := keyutil.PublicKeysFromFile(publicKeyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't load the public key file %s", publicKeyPath)
+	}
+	return verifyPublicKey(pubKeys[0])
+}
+
+// TryLoadPublicKeyFromString load a PEM encoded public key, RSA and ECDSA keys are allowed
+func TryLoadPublicKeyFromString(data string) (crypto.PublicKey, error) {
+
+	pubKeys, err := keyutil.ParsePublicKeysPEM([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %v", err)
+	}
+	return verifyPublicKey(pubKeys[0])
+}
+
+func verifyPublicKey(key interface{}) (crypto.PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		return k, nil
+	}
+	return nil, errors.New("the public key is neither in RSA nor ECDSA format")
+}
+
 // TryLoadCSRFromDisk tries to load the CSR from the disk
 func TryLoadCSRFromDisk(pkiPath, name string) (*x509.CertificateRequest, error) {
 	csrPath := pathForCSR(pkiPath, name)
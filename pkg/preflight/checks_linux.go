@@ -0,0 +1,354 @@
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/docker"
+	"github.com/QQGoblin/go-sdk/pkg/executil"
+	"github.com/QQGoblin/go-sdk/pkg/initsystem"
+	"github.com/QQGoblin/go-sdk/pkg/kmod"
+	"github.com/QQGoblin/go-sdk/pkg/network"
+	"github.com/QQGoblin/go-sdk/pkg/sysctl"
+)
+
+const (
+	// DefaultCgroupRoot is where the cgroup hierarchy is mounted
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+	// DefaultDockerSocket is the socket dockerd listens on
+	DefaultDockerSocket = "/var/run/docker.sock"
+)
+
+// SwapCheck fails if any swap device or file is active, the kubelet refuses to start with swap on by
+// default
+type SwapCheck struct {
+	// ProcRoot is where the proc filesystem is mounted, "/proc" if empty
+	ProcRoot string
+}
+
+func (SwapCheck) Name() string {
+	return "Swap"
+}
+
+func (c SwapCheck) Check(_ context.Context) []Finding {
+
+	procRoot := c.ProcRoot
+	if procRoot == "" {
+		procRoot = "/proc"
+	}
+	f, err := os.Open(filepath.Join(procRoot, "swaps"))
+	if err != nil {
+		return []Finding{Warningf("unable to read active swaps: %v", err)}
+	}
+	defer f.Close()
+
+	// the first line is the "Filename Type Size Used Priority" header
+	devices := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for header := true; scanner.Scan(); header = false {
+		fields := strings.Fields(scanner.Text())
+		if header || len(fields) == 0 {
+			continue
+		}
+		devices = append(devices, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return []Finding{Warningf("unable to read active swaps: %v", err)}
+	}
+	if len(devices) > 0 {
+		return []Finding{Errorf("swap is enabled on %s, disable it with swapoff -a and remove it from /etc/fstab", strings.Join(devices, ", "))}
+	}
+	return nil
+}
+
+// SysctlCheck fails for every key whose live value differs from the required one, keys may be given
+// with dots or slashes
+type SysctlCheck struct {
+	Values map[string]string
+}
+
+func (SysctlCheck) Name() string {
+	return "Sysctl"
+}
+
+func (c SysctlCheck) Check(_ context.Context) []Finding {
+
+	keys := make([]string, 0, len(c.Values))
+	for k := range c.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	findings := make([]Finding, 0)
+	for _, key := range keys {
+		want := sysctl.NormalizeValue(c.Values[key])
+		value, err := sysctl.Sysctl(key)
+		if err != nil {
+			findings = append(findings, Errorf("unable to read %s: %v", key, err))
+			continue
+		}
+		if got := sysctl.NormalizeValue(value); got != want {
+			findings = append(findings, Errorf("%s is %q, expected %q", key, got, want))
+		}
+	}
+	return findings
+}
+
+// KernelModuleCheck fails for every module which is neither loaded nor built into the kernel
+type KernelModuleCheck struct {
+	Modules []string
+	// Manager looks up the modules, the host if nil
+	Manager *kmod.Manager
+}
+
+func (KernelModuleCheck) Name() string {
+	return "KernelModules"
+}
+
+func (c KernelModuleCheck) Check(_ context.Context) []Finding {
+
+	m := c.Manager
+	if m == nil {
+		m = kmod.NewManager(nil)
+	}
+	findings := make([]Finding, 0)
+	for _, module := range c.Modules {
+		loaded, err := m.IsLoaded(module)
+		if err != nil {
+			return append(findings, Errorf("unable to list kernel modules: %v", err))
+		}
+		if !loaded {
+			findings = append(findings, Errorf("kernel module %s is not loaded", module))
+		}
+	}
+	return findings
+}
+
+// CgroupCheck reports the cgroup version of the host and fails if it is not Version
+type CgroupCheck struct {
+	// Version is 1 or 2, 0 accepts both
+	Version int
+	// Root is where the cgroup hierarchy is mounted, DefaultCgroupRoot if empty
+	Root string
+}
+
+func (CgroupCheck) Name() string {
+	return "CgroupVersion"
+}
+
+func (c CgroupCheck) Check(_ context.Context) []Finding {
+
+	root := c.Root
+	if root == "" {
+		root = DefaultCgroupRoot
+	}
+	if _, err := os.Stat(root); err != nil {
+		return []Finding{Errorf("cgroup filesystem is not mounted at %s: %v", root, err)}
+	}
+
+	// the unified hierarchy has cgroup.controllers at its root, v1 and hybrid setups mount one
+	// directory per controller instead
+	version := 1
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		version = 2
+	}
+	if c.Version != 0 && c.Version != version {
+		return []Finding{Errorf("cgroup v%d is in use, cgroup v%d is required", version, c.Version)}
+	}
+	return []Finding{Infof("cgroup v%d is in use", version)}
+}
+
+// ServiceCheck fails if the service is not running and warns if it is not enabled at boot
+type ServiceCheck struct {
+	Service string
+	// InitSystem queries the service, the detected init system of the host if nil
+	InitSystem initsystem.InitSystem
+}
+
+func (c ServiceCheck) Name() string {
+	return "Service-" + c.Service
+}
+
+func (c ServiceCheck) Check(_ context.Context) []Finding {
+
+	is := c.InitSystem
+	if is == nil {
+		var err error
+		if is, err = initsystem.GetInitSystem(); err != nil {
+			return []Finding{Errorf("unable to get status of %s: %v", c.Service, err)}
+		}
+	}
+	status, err := is.ServiceStatus(c.Service)
+	if err != nil {
+		return []Finding{Errorf("unable to get status of %s: %v", c.Service, err)}
+	}
+	if !status.Exists() {
+		return []Finding{Errorf("service %s does not exist", c.Service)}
+	}
+	findings := make([]Finding, 0)
+	if !status.IsActive() {
+		findings = append(findings, Errorf("service %s is %s (%s)", c.Service, status.ActiveState, status.SubState))
+	}
+	if !status.IsEnabled() {
+		findings = append(findings, Warningf("service %s is not enabled, it will not start at boot", c.Service))
+	}
+	return findings
+}
+
+// DockerCheck fails if the docker daemon does not answer on Socket
+type DockerCheck struct {
+	// Socket is DefaultDockerSocket if empty
+	Socket  string
+	Timeout time.Duration
+}
+
+func (DockerCheck) Name() string {
+	return "ContainerRuntime"
+}
+
+func (c DockerCheck) Check(_ context.Context) []Finding {
+
+	socket := c.Socket
+	if socket == "" {
+		socket = DefaultDockerSocket
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	client, err := docker.NewSocketClient(socket, timeout)
+	if err != nil {
+		return []Finding{Errorf("unable to connect to %s: %v", socket, err)}
+	}
+	info, err := client.Info()
+	if err != nil {
+		return []Finding{Errorf("docker is not running on %s: %v", socket, err)}
+	}
+	return []Finding{Infof("docker %s is running", info.ServerVersion)}
+}
+
+// PortsCheck fails for every port taken by another socket
+type PortsCheck struct {
+	// Protocol is network.ProtocolTCP or network.ProtocolUDP
+	Protocol string
+	Ports    []int
+}
+
+func (c PortsCheck) Name() string {
+	return "Ports-" + c.Protocol
+}
+
+func (c PortsCheck) Check(_ context.Context) []Finding {
+
+	statuses, err := network.CheckLocalPorts(c.Protocol, c.Ports...)
+	if err != nil {
+		return []Finding{Errorf("unable to check %s ports: %v", c.Protocol, err)}
+	}
+	findings := make([]Finding, 0)
+	for _, s := range statuses {
+		if s.InUse {
+			findings = append(findings, Errorf("%s", s))
+		}
+	}
+	return findings
+}
+
+// ReachabilityCheck fails for every node which does not accept tcp connections on one of Ports
+type ReachabilityCheck struct {
+	Nodes []string
+	Ports []int
+	// Timeout bounds every dial, network.DefaultDialTimeout if not set
+	Timeout time.Duration
+	// Parallelism is how many dials run at a time, network.DefaultDialConcurrency if not set
+	Parallelism int
+}
+
+func (ReachabilityCheck) Name() string {
+	return "Reachability"
+}
+
+func (c ReachabilityCheck) Check(ctx context.Context) []Finding {
+
+	findings := make([]Finding, 0)
+	for _, port := range c.Ports {
+		results := network.CheckNodesReachability(ctx, c.Nodes, strconv.Itoa(port), c.Timeout, c.Parallelism)
+		for _, r := range results {
+			if !r.Reachable {
+				findings = append(findings, Errorf("%s is not reachable: %s", r.Address, r.Error))
+			}
+		}
+	}
+	return findings
+}
+
+// PathMTUCheck probes the path mtu to every node and fails for nodes with a path mtu below MinMTU.
+// Probing requires CAP_NET_RAW.
+type PathMTUCheck struct {
+	Nodes  []string
+	MinMTU int
+}
+
+func (PathMTUCheck) Name() string {
+	return "PathMTU"
+}
+
+func (c PathMTUCheck) Check(ctx context.Context) []Finding {
+
+	findings := make([]Finding, 0)
+	for _, node := range c.Nodes {
+		mtu, err := network.ProbePathMTU(ctx, node, 0, 0)
+		switch {
+		case err != nil:
+			findings = append(findings, Errorf("unable to probe path mtu to %s: %v", node, err))
+		case mtu < c.MinMTU:
+			findings = append(findings, Errorf("path mtu to %s is %d, below %d", node, mtu, c.MinMTU))
+		default:
+			findings = append(findings, Infof("path mtu to %s is %d", node, mtu))
+		}
+	}
+	return findings
+}
+
+// TimeSyncCheck warns if the clock is not synchronized, certificates issued by a node with a skewed
+// clock are rejected by its peers. It asks timedatectl and falls back to chronyc.
+type TimeSyncCheck struct {
+	// Exec runs the commands, the host if nil
+	Exec executil.Executor
+}
+
+func (TimeSyncCheck) Name() string {
+	return "TimeSync"
+}
+
+func (c TimeSyncCheck) Check(_ context.Context) []Finding {
+
+	exec := c.Exec
+	if exec == nil {
+		exec = executil.New()
+	}
+
+	if _, err := exec.LookPath("timedatectl"); err == nil {
+		out, err := exec.Run(nil, "timedatectl", "show", "--property=NTPSynchronized", "--value")
+		if err == nil {
+			if strings.TrimSpace(string(out)) == "yes" {
+				return nil
+			}
+			return []Finding{Warningf("system clock is not synchronized, enable ntp with timedatectl set-ntp true")}
+		}
+	}
+
+	if _, err := exec.LookPath("chronyc"); err == nil {
+		// chronyc waitsync with a single try exits non-zero unless the clock is synchronized
+		if _, err := exec.Run(nil, "chronyc", "waitsync", "1"); err == nil {
+			return nil
+		}
+		return []Finding{Warningf("system clock is not synchronized according to chronyd")}
+	}
+	return []Finding{Warningf("unable to determine whether the system clock is synchronized")}
+}
@@ -0,0 +1,237 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/concurrency"
+)
+
+// Severity orders the findings of a check, only SeverityError fails a run
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+
+	// SkipAll in RunOptions.Skip skips every check
+	SkipAll = "all"
+
+	DefaultParallelism = 4
+)
+
+// Finding is one message of a check
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("[%s] %s", f.Severity, f.Message)
+}
+
+// Infof, Warningf and Errorf build findings of the respective severity
+func Infof(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityInfo, Message: fmt.Sprintf(format, args...)}
+}
+
+func Warningf(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)}
+}
+
+func Errorf(format string, args ...interface{}) Finding {
+	return Finding{Severity: SeverityError, Message: fmt.Sprintf(format, args...)}
+}
+
+// Checker validates one aspect of the node, it returns nothing if the node is fine. Checks run
+// concurrently, so Check must not depend on other checks.
+type Checker interface {
+	// Name identifies the check in reports and skip lists, e.g. "Swap"
+	Name() string
+	Check(ctx context.Context) []Finding
+}
+
+// CheckFunc adapts a function to a Checker
+func CheckFunc(name string, fn func(ctx context.Context) []Finding) Checker {
+	return &checkFunc{name: name, fn: fn}
+}
+
+type checkFunc struct {
+	name string
+	fn   func(ctx context.Context) []Finding
+}
+
+func (c *checkFunc) Name() string {
+	return c.name
+}
+
+func (c *checkFunc) Check(ctx context.Context) []Finding {
+	return c.fn(ctx)
+}
+
+// RunOptions controls how Run executes the checks
+type RunOptions struct {
+	// Skip lists the names of checks which are not run, case insensitive, SkipAll skips them all
+	Skip []string
+	// Parallelism is how many checks run at a time, DefaultParallelism if not set
+	Parallelism int
+	// Timeout bounds every single check, no limit if not set
+	Timeout time.Duration
+}
+
+func (o RunOptions) skipped(name string) bool {
+	for _, s := range o.Skip {
+		if strings.EqualFold(s, name) || strings.EqualFold(s, SkipAll) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckResult is the outcome of one check
+type CheckResult struct {
+	Name     string        `json:"name"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	Findings []Finding     `json:"findings"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Severity returns the highest severity of the findings, empty if there are none
+func (r CheckResult) Severity() Severity {
+	var severity Severity
+	for _, f := range r.Findings {
+		switch {
+		case f.Severity == SeverityError:
+			return SeverityError
+		case f.Severity == SeverityWarning:
+			severity = SeverityWarning
+		case severity == "":
+			severity = SeverityInfo
+		}
+	}
+	return severity
+}
+
+// Report is the structured result of Run, results are ordered like the checks
+type Report struct {
+	Results []CheckResult `json:"results"`
+}
+
+// Passed returns true if no check reported an error
+func (r *Report) Passed() bool {
+	return len(r.Findings(SeverityError)) == 0
+}
+
+// Findings returns the findings of severity of every check, prefixed with the check name
+func (r *Report) Findings(severity Severity) []string {
+	messages := make([]string, 0)
+	for _, result := range r.Results {
+		for _, f := range result.Findings {
+			if f.Severity == severity {
+				messages = append(messages, fmt.Sprintf("%s: %s", result.Name, f.Message))
+			}
+		}
+	}
+	return messages
+}
+
+// Err returns an error listing every error finding, nil if the report passed
+func (r *Report) Err() error {
+	errs := r.Findings(SeverityError)
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("preflight checks failed:\n\t%s", strings.Join(errs, "\n\t"))
+}
+
+// WriteJSON writes the indented report
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes one line per check followed by its findings
+func (r *Report) WriteText(w io.Writer) error {
+	for _, result := range r.Results {
+		status := "PASS"
+		switch {
+		case result.Skipped:
+			status = "SKIP"
+		case result.Severity() == SeverityError:
+			status = "FAIL"
+		case result.Severity() == SeverityWarning:
+			status = "WARN"
+		}
+		if _, err := fmt.Fprintf(w, "%-4s %s (%s)\n", status, result.Name, result.Duration.Round(time.Millisecond)); err != nil {
+			return err
+		}
+		for _, f := range result.Findings {
+			if _, err := fmt.Fprintf(w, "     %s\n", f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes the checks not skipped by opts in parallel and collects their findings. A check still
+// running when ctx is done is reported as an error.
+func Run(ctx context.Context, checks []Checker, opts RunOptions) *Report {
+
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = DefaultParallelism
+	}
+
+	report := &Report{Results: make([]CheckResult, len(checks))}
+	wg := concurrency.NewWaitGroup(opts.Parallelism)
+	for i := range checks {
+		name := checks[i].Name()
+		if opts.skipped(name) {
+			report.Results[i] = CheckResult{Name: name, Skipped: true, Findings: []Finding{}}
+			continue
+		}
+		wg.BlockAdd()
+		go func(i int) {
+			defer wg.Done()
+			report.Results[i] = runCheck(ctx, checks[i], opts.Timeout)
+		}(i)
+	}
+	wg.Wait()
+	return report
+}
+
+func runCheck(ctx context.Context, check Checker, timeout time.Duration) CheckResult {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	result := CheckResult{Name: check.Name(), Findings: []Finding{}}
+	start := time.Now()
+	done := make(chan []Finding, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- []Finding{Errorf("check panicked: %v", r)}
+			}
+		}()
+		done <- check.Check(ctx)
+	}()
+
+	select {
+	case findings := <-done:
+		result.Findings = append(result.Findings, findings...)
+	case <-ctx.Done():
+		result.Findings = append(result.Findings, Errorf("check did not finish: %v", ctx.Err()))
+	}
+	result.Duration = time.Since(start)
+	return result
+}
@@ -0,0 +1,142 @@
+package sysctl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigDirs are the sysctl.d directories in precedence order, a file in an earlier directory hides a
+// file with the same name in a later one
+var ConfigDirs = []string{"/etc/sysctl.d", "/run/sysctl.d", "/usr/local/lib/sysctl.d", "/usr/lib/sysctl.d", "/lib/sysctl.d"}
+
+// ConfigFile is read after all sysctl.d files, so its values win
+const ConfigFile = "/etc/sysctl.conf"
+
+// Entry is one "key = value" assignment of a sysctl config file
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// IgnoreErrors is set by a leading "-", failing to set the key is not an error then
+	IgnoreErrors bool   `json:"ignoreErrors,omitempty"`
+	File         string `json:"file,omitempty"`
+	Line         int    `json:"line,omitempty"`
+}
+
+// ParseConfig parses a sysctl.conf(5) file, keys are returned in dotted form
+func ParseConfig(r io.Reader, file string) ([]Entry, error) {
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s:%d: missing '='", file, line)
+		}
+		entry := Entry{
+			Key:   strings.TrimSpace(kv[0]),
+			Value: strings.TrimSpace(kv[1]),
+			File:  file,
+			Line:  line,
+		}
+		if strings.HasPrefix(entry.Key, "-") {
+			entry.IgnoreErrors = true
+			entry.Key = strings.TrimSpace(entry.Key[1:])
+		}
+		if entry.Key == "" {
+			return nil, fmt.Errorf("%s:%d: empty key", file, line)
+		}
+		entry.Key = NormalizeKey(entry.Key)
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// NormalizeKey returns the dotted form of a key given with dots or slashes, e.g. "net/ipv4/ip_forward".
+// Dots in interface names are written as "/" like sysctl(8) does, "net.ipv4.conf.eth0/100.rp_filter".
+func NormalizeKey(key string) string {
+	return strings.NewReplacer("/", ".", ".", "/").Replace(toNormalName(strings.TrimPrefix(key, "/")))
+}
+
+// NormalizeValue joins the fields of a value with single spaces, /proc separates them with tabs
+func NormalizeValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// configFiles returns the files systemd-sysctl reads below root, in the order they are applied
+func configFiles(root string) ([]string, error) {
+
+	byName := make(map[string]string)
+	for _, dir := range ConfigDirs {
+		infos, err := ioutil.ReadDir(filepath.Join(root, dir))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if info.IsDir() || !strings.HasSuffix(info.Name(), ".conf") {
+				continue
+			}
+			if _, ok := byName[info.Name()]; !ok {
+				byName[info.Name()] = filepath.Join(root, dir, info.Name())
+			}
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		files = append(files, byName[name])
+	}
+	if _, err := os.Stat(filepath.Join(root, ConfigFile)); err == nil {
+		files = append(files, filepath.Join(root, ConfigFile))
+	}
+	return files, nil
+}
+
+// readConfig returns the effective persisted entries below root, later files override earlier ones
+func readConfig(root string) (map[string]Entry, error) {
+
+	files, err := configFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	effective := make(map[string]Entry)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			// dangling symlinks, e.g. 99-sysctl.conf -> ../sysctl.conf
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		entries, err := ParseConfig(f, file)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			effective[e.Key] = e
+		}
+	}
+	return effective, nil
+}
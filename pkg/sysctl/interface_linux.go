@@ -0,0 +1,176 @@
+package sysctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/network"
+)
+
+// Family selects the ipv4 or ipv6 tree of per interface keys
+type Family string
+
+const (
+	IPv4 Family = "ipv4"
+	IPv6 Family = "ipv6"
+
+	// InterfaceAll and InterfaceDefault are the pseudo interfaces changing all and new interfaces
+	InterfaceAll     = "all"
+	InterfaceDefault = "default"
+
+	// maxInterfaceNameLen is IFNAMSIZ without the terminating null byte
+	maxInterfaceNameLen = 15
+)
+
+// RPFilter is the reverse path filtering mode of net.ipv4.conf.<iface>.rp_filter
+type RPFilter int
+
+const (
+	RPFilterOff    RPFilter = 0
+	RPFilterStrict RPFilter = 1
+	RPFilterLoose  RPFilter = 2
+)
+
+// GetInNetNS reads a key inside the network namespace at nsPath, an empty nsPath reads the current one.
+// Only net.* keys differ between namespaces.
+func GetInNetNS(nsPath, name string) (string, error) {
+	var value string
+	err := network.WithNetNS(nsPath, func() error {
+		var err error
+		value, err = getSysctl(name)
+		return err
+	})
+	return value, err
+}
+
+// SetInNetNS writes a key inside the network namespace at nsPath and returns the value read back
+func SetInNetNS(nsPath, name, value string) (string, error) {
+	var result string
+	err := network.WithNetNS(nsPath, func() error {
+		var err error
+		result, err = setSysctl(name, value)
+		return err
+	})
+	return result, err
+}
+
+// InterfaceKey returns the slash separated key of a per interface knob, which keeps dots in the
+// interface name intact, e.g. "net/ipv4/conf/eth0.100/rp_filter"
+func InterfaceKey(family Family, iface, knob string) string {
+	return strings.Join([]string{"net", string(family), "conf", iface, knob}, "/")
+}
+
+// Interface reads and writes the sysctl knobs of a network interface, in the namespace at NetNS or
+// the current one if it is empty
+type Interface struct {
+	Name  string
+	NetNS string
+}
+
+// NewInterface returns the knobs of the interface name inside the network namespace at nsPath
+func NewInterface(name, nsPath string) (*Interface, error) {
+	if err := ValidateInterfaceName(name); err != nil {
+		return nil, err
+	}
+	return &Interface{Name: name, NetNS: nsPath}, nil
+}
+
+// ValidateInterfaceName checks name can be used in a per interface key
+func ValidateInterfaceName(name string) error {
+	if name == "" || len(name) > maxInterfaceNameLen {
+		return fmt.Errorf("invalid interface name %q", name)
+	}
+	if strings.ContainsAny(name, "/ \t\n") || name == "." || name == ".." {
+		return fmt.Errorf("invalid interface name %q", name)
+	}
+	return nil
+}
+
+// Get reads a knob of the interface
+func (i *Interface) Get(family Family, knob string) (string, error) {
+	return GetInNetNS(i.NetNS, InterfaceKey(family, i.Name, knob))
+}
+
+// Set writes a knob of the interface
+func (i *Interface) Set(family Family, knob, value string) error {
+	_, err := SetInNetNS(i.NetNS, InterfaceKey(family, i.Name, knob), value)
+	return err
+}
+
+func (i *Interface) getInt(family Family, knob string) (int, error) {
+	value, err := i.Get(family, knob)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q of %s", value, InterfaceKey(family, i.Name, knob))
+	}
+	return n, nil
+}
+
+func (i *Interface) setInt(family Family, knob string, value, min, max int) error {
+	if value < min || value > max {
+		return fmt.Errorf("%s must be between %d and %d, got %d", knob, min, max, value)
+	}
+	return i.Set(family, knob, strconv.Itoa(value))
+}
+
+// Forwarding returns whether the interface forwards packets of family
+func (i *Interface) Forwarding(family Family) (bool, error) {
+	n, err := i.getInt(family, "forwarding")
+	return n != 0, err
+}
+
+// SetForwarding enables or disables forwarding of family on the interface
+func (i *Interface) SetForwarding(family Family, enabled bool) error {
+	value := 0
+	if enabled {
+		value = 1
+	}
+	return i.setInt(family, "forwarding", value, 0, 1)
+}
+
+// RPFilter returns the reverse path filtering mode, which only exists for ipv4
+func (i *Interface) RPFilter() (RPFilter, error) {
+	n, err := i.getInt(IPv4, "rp_filter")
+	return RPFilter(n), err
+}
+
+// SetRPFilter sets the reverse path filtering mode, the kernel uses the maximum of the interface and
+// the "all" setting
+func (i *Interface) SetRPFilter(mode RPFilter) error {
+	return i.setInt(IPv4, "rp_filter", int(mode), int(RPFilterOff), int(RPFilterLoose))
+}
+
+// ArpIgnore returns when the interface answers arp requests, 0 to 8
+func (i *Interface) ArpIgnore() (int, error) {
+	return i.getInt(IPv4, "arp_ignore")
+}
+
+// SetArpIgnore sets when the interface answers arp requests, e.g. 1 only for addresses configured on
+// the interface itself, as needed by ipvs direct routing
+func (i *Interface) SetArpIgnore(mode int) error {
+	return i.setInt(IPv4, "arp_ignore", mode, 0, 8)
+}
+
+// ArpAnnounce returns which source address arp requests use, 0 to 2
+func (i *Interface) ArpAnnounce() (int, error) {
+	return i.getInt(IPv4, "arp_announce")
+}
+
+// SetArpAnnounce sets which source address arp requests use, 2 always uses the best local address
+func (i *Interface) SetArpAnnounce(mode int) error {
+	return i.setInt(IPv4, "arp_announce", mode, 0, 2)
+}
+
+// AcceptRA returns whether ipv6 router advertisements are accepted, 2 accepts them while forwarding
+func (i *Interface) AcceptRA() (int, error) {
+	return i.getInt(IPv6, "accept_ra")
+}
+
+// SetAcceptRA sets whether ipv6 router advertisements are accepted, 0 to 2
+func (i *Interface) SetAcceptRA(mode int) error {
+	return i.setInt(IPv6, "accept_ra", mode, 0, 2)
+}
@@ -0,0 +1,214 @@
+package sysctl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/QQGoblin/go-sdk/pkg/fileutil"
+)
+
+const (
+	// DefaultPriority orders profile files after the distribution defaults in 10-* to 50-*
+	DefaultPriority = 90
+
+	configFilePerm = 0644
+)
+
+// Profile is a named set of sysctl values, persisted as /etc/sysctl.d/<priority>-<name>.conf
+type Profile struct {
+	Name string
+	// Priority is the NN prefix of the file, files with a higher prefix override lower ones
+	Priority int
+	Values   map[string]string
+}
+
+// NewProfile returns a profile with DefaultPriority, keys may be given with dots or slashes
+func NewProfile(name string, values map[string]string) *Profile {
+	p := &Profile{Name: name, Priority: DefaultPriority, Values: make(map[string]string, len(values))}
+	for k, v := range values {
+		p.Values[NormalizeKey(k)] = v
+	}
+	return p
+}
+
+// FileName returns the sysctl.d file name, e.g. "90-kubernetes.conf"
+func (p *Profile) FileName() string {
+	return fmt.Sprintf("%02d-%s.conf", p.Priority, p.Name)
+}
+
+// Keys returns the keys in sorted order
+func (p *Profile) Keys() []string {
+	keys := make([]string, 0, len(p.Values))
+	for k := range p.Values {
+		keys = append(keys, NormalizeKey(k))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (p *Profile) value(key string) string {
+	if v, ok := p.Values[key]; ok {
+		return v
+	}
+	// keys given with slashes
+	for k, v := range p.Values {
+		if NormalizeKey(k) == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// Content renders the sysctl.d file of the profile, sorted by key
+func (p *Profile) Content() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# sysctl profile %s\n", p.Name)
+	for _, k := range p.Keys() {
+		fmt.Fprintf(&b, "%s = %s\n", k, p.value(k))
+	}
+	return b.Bytes()
+}
+
+// Drift compares the desired value of a key with the persisted and the live one
+type Drift struct {
+	Key     string `json:"key"`
+	Desired string `json:"desired"`
+	// Persisted is the value the sysctl.d files set after a reboot, PersistedFile the file setting it
+	Persisted     string `json:"persisted,omitempty"`
+	PersistedFile string `json:"persistedFile,omitempty"`
+	Live          string `json:"live,omitempty"`
+	// LiveError is set if the key could not be read, e.g. the module providing it is not loaded
+	LiveError string `json:"liveError,omitempty"`
+}
+
+// PersistedDrift returns true if the value set at boot differs from the desired one
+func (d Drift) PersistedDrift() bool {
+	return NormalizeValue(d.Persisted) != NormalizeValue(d.Desired)
+}
+
+// LiveDrift returns true if the running kernel has a different value
+func (d Drift) LiveDrift() bool {
+	return d.LiveError != "" || NormalizeValue(d.Live) != NormalizeValue(d.Desired)
+}
+
+// Manager applies and persists sysctl profiles
+type Manager struct {
+	// Root is the filesystem the sysctl.d directories are found in, "/" by default
+	Root string
+	// ProcRoot is where the proc filesystem is mounted, ProcRoot by default
+	ProcRoot string
+}
+
+// NewManager returns a Manager for the host
+func NewManager() *Manager {
+	return &Manager{Root: "/", ProcRoot: ProcRoot}
+}
+
+func (m *Manager) procPath(key string) string {
+	procRoot := m.ProcRoot
+	if procRoot == "" {
+		procRoot = ProcRoot
+	}
+	return filepath.Join(procRoot, "sys", toNormalName(key))
+}
+
+// Get reads the live value of key
+func (m *Manager) Get(key string) (string, error) {
+	data, err := ioutil.ReadFile(m.procPath(key))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// Set writes the live value of key
+func (m *Manager) Set(key, value string) error {
+	return ioutil.WriteFile(m.procPath(key), []byte(value), 0644)
+}
+
+// Apply sets the live values of the profile in one transaction and persists it once they all applied,
+// it returns the warnings of the transaction
+func (m *Manager) Apply(p *Profile, opts ApplyOptions) ([]string, error) {
+	values := make(map[string]string, len(p.Values))
+	for _, k := range p.Keys() {
+		values[k] = p.value(k)
+	}
+	warnings, err := m.ApplyValues(values, opts)
+	if err != nil {
+		return warnings, err
+	}
+	_, err = m.Persist(p)
+	return warnings, err
+}
+
+// Path returns where the profile is persisted
+func (m *Manager) Path(p *Profile) string {
+	return filepath.Join(m.Root, ConfigDirs[0], p.FileName())
+}
+
+// Persist writes the profile file if its content changed, it returns true if it was written
+func (m *Manager) Persist(p *Profile) (bool, error) {
+
+	path := m.Path(p)
+	content := p.Content()
+	current, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	if err := fileutil.WriteFileAtomic(path, content, configFilePerm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Remove deletes the profile file, live values are left as they are
+func (m *Manager) Remove(p *Profile) error {
+	if err := os.Remove(m.Path(p)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Persisted returns the values the sysctl.d files and sysctl.conf set at boot, with the file setting them
+func (m *Manager) Persisted() (map[string]Entry, error) {
+	return readConfig(m.Root)
+}
+
+// Drift reports every key of the profile whose persisted or live value differs from the desired one
+func (m *Manager) Drift(p *Profile) ([]Drift, error) {
+
+	persisted, err := m.Persisted()
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := make([]Drift, 0)
+	for _, k := range p.Keys() {
+		d := Drift{Key: k, Desired: p.value(k)}
+		if e, ok := persisted[k]; ok {
+			d.Persisted, d.PersistedFile = e.Value, e.File
+		}
+		live, err := m.Get(k)
+		if err != nil {
+			d.LiveError = err.Error()
+		} else {
+			d.Live = live
+		}
+		if d.PersistedDrift() || d.LiveDrift() {
+			drifts = append(drifts, d)
+		}
+	}
+	return drifts, nil
+}
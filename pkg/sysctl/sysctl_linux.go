@@ -21,6 +21,9 @@ import (
 	"strings"
 )
 
+// ProcRoot is where the proc filesystem is read from, tests may point it to a fixture directory
+var ProcRoot = "/proc"
+
 // Sysctl provides a method to set/get values from /proc/sys - in linux systems
 // new interface to set/get values of variables formerly handled by sysctl syscall
 // If optional `params` have only one string value - this function will
@@ -35,7 +38,7 @@ func Sysctl(name string, params ...string) (string, error) {
 }
 
 func getSysctl(name string) (string, error) {
-	fullName := filepath.Join("/proc/sys", toNormalName(name))
+	fullName := filepath.Join(ProcRoot, "sys", toNormalName(name))
 	data, err := ioutil.ReadFile(fullName)
 	if err != nil {
 		return "", err
@@ -45,7 +48,7 @@ func getSysctl(name string) (string, error) {
 }
 
 func setSysctl(name, value string) (string, error) {
-	fullName := filepath.Join("/proc/sys", toNormalName(name))
+	fullName := filepath.Join(ProcRoot, "sys", toNormalName(name))
 	if err := ioutil.WriteFile(fullName, []byte(value), 0644); err != nil {
 		return "", err
 	}
@@ -72,7 +75,21 @@ func toNormalName(name string) string {
 
 	if interchange {
 		r := strings.NewReplacer(".", "/", "/", ".")
+		// interface names may contain dots, e.g. vlan "eth0.100", sysctl(8) writes them as "eth0/100"
+		// but the name is taken as everything up to the last separator either way
+		for _, prefix := range interfaceKeyPrefixes {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			rest := name[len(prefix):]
+			if i := strings.LastIndex(rest, "."); i > 0 {
+				return r.Replace(prefix) + strings.ReplaceAll(rest[:i], "/", ".") + "/" + rest[i+1:]
+			}
+		}
 		return r.Replace(name)
 	}
 	return name
 }
+
+// interfaceKeyPrefixes are followed by an interface name in dotted keys
+var interfaceKeyPrefixes = []string{"net.ipv4.conf.", "net.ipv6.conf.", "net.ipv4.neigh.", "net.ipv6.neigh."}
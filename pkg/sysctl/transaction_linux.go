@@ -0,0 +1,183 @@
+package sysctl
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Range bounds every numeric field of a value, both ends included
+type Range struct {
+	Min int64
+	Max int64
+}
+
+// DefaultRanges are the valid values of commonly tuned keys, pass them as ApplyOptions.Ranges
+var DefaultRanges = map[string]Range{
+	"net.ipv4.ip_forward":                 {0, 1},
+	"net.ipv6.conf.all.forwarding":        {0, 1},
+	"net.bridge.bridge-nf-call-iptables":  {0, 1},
+	"net.bridge.bridge-nf-call-ip6tables": {0, 1},
+	"net.ipv4.conf.all.rp_filter":         {0, 2},
+	"net.ipv4.tcp_syncookies":             {0, 2},
+	"net.core.somaxconn":                  {0, 1<<31 - 1},
+	"vm.swappiness":                       {0, 200},
+	"vm.overcommit_memory":                {0, 2},
+	"vm.panic_on_oom":                     {0, 2},
+	"kernel.panic":                        {-1 << 31, 1<<31 - 1},
+	"kernel.panic_on_oops":                {0, 1},
+	"fs.inotify.max_user_watches":         {1, 1<<31 - 1},
+	"fs.inotify.max_user_instances":       {1, 1<<31 - 1},
+}
+
+// ApplyOptions tunes a transaction
+type ApplyOptions struct {
+	// IgnoreUnknown skips keys the kernel does not provide and reports them as warnings instead of
+	// failing the transaction
+	IgnoreUnknown bool
+	// Ranges are checked for the keys they list before anything is written
+	Ranges map[string]Range
+}
+
+// TransactionError reports the key which failed a transaction, and whether restoring the snapshot failed
+type TransactionError struct {
+	Key         string
+	Err         error
+	RollbackErr error
+}
+
+func (e *TransactionError) Error() string {
+	msg := fmt.Sprintf("failed to set %s: %v", e.Key, e.Err)
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf(", rollback failed: %v", e.RollbackErr)
+	}
+	return msg
+}
+
+// Transaction writes a set of values, restoring the previous ones if any write fails
+type Transaction struct {
+	m    *Manager
+	opts ApplyOptions
+
+	// snapshot holds the value of every written key from before the transaction, written lists the
+	// writes in order
+	snapshot map[string]string
+	written  []string
+	// Warnings lists the keys skipped with IgnoreUnknown
+	Warnings []string
+}
+
+// Begin starts a transaction
+func (m *Manager) Begin(opts ApplyOptions) *Transaction {
+	return &Transaction{
+		m:        m,
+		opts:     opts,
+		snapshot: make(map[string]string),
+		written:  make([]string, 0),
+		Warnings: make([]string, 0),
+	}
+}
+
+// Apply validates all values, then writes them in key order. If a write fails every key written by the
+// transaction so far is restored and a *TransactionError is returned. Apply may be called several
+// times, a failure rolls back the writes of earlier calls as well.
+func (t *Transaction) Apply(values map[string]string) error {
+
+	keys := make([]string, 0, len(values))
+	normalized := make(map[string]string, len(values))
+	for k, v := range values {
+		key := NormalizeKey(k)
+		keys = append(keys, key)
+		normalized[key] = v
+	}
+	sort.Strings(keys)
+
+	// validate everything before the first write
+	apply := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if _, err := os.Stat(t.m.procPath(k)); os.IsNotExist(err) {
+			if t.opts.IgnoreUnknown {
+				t.Warnings = append(t.Warnings, fmt.Sprintf("unknown key %s skipped", k))
+				continue
+			}
+			return &TransactionError{Key: k, Err: fmt.Errorf("unknown key")}
+		}
+		if r, ok := t.opts.Ranges[k]; ok {
+			if err := r.Validate(normalized[k]); err != nil {
+				return &TransactionError{Key: k, Err: err}
+			}
+		}
+		apply = append(apply, k)
+	}
+
+	for _, k := range apply {
+		if _, ok := t.snapshot[k]; !ok {
+			previous, err := t.m.Get(k)
+			if err != nil {
+				return t.fail(k, fmt.Errorf("failed to read current value: %v", err))
+			}
+			t.snapshot[k] = previous
+		}
+		t.written = append(t.written, k)
+		if err := t.m.Set(k, normalized[k]); err != nil {
+			return t.fail(k, err)
+		}
+	}
+	return nil
+}
+
+// Snapshot returns the values the written keys had before the transaction
+func (t *Transaction) Snapshot() map[string]string {
+	snapshot := make(map[string]string, len(t.snapshot))
+	for k, v := range t.snapshot {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// Rollback restores the written keys in reverse order
+func (t *Transaction) Rollback() error {
+	failed := make([]string, 0)
+	for i := len(t.written) - 1; i >= 0; i-- {
+		k := t.written[i]
+		if err := t.m.Set(k, t.snapshot[k]); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", k, err))
+		}
+	}
+	t.written = t.written[:0]
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (t *Transaction) fail(key string, err error) error {
+	return &TransactionError{Key: key, Err: err, RollbackErr: t.Rollback()}
+}
+
+// ApplyValues writes values in a single transaction and returns its warnings
+func (m *Manager) ApplyValues(values map[string]string, opts ApplyOptions) ([]string, error) {
+	tx := m.Begin(opts)
+	err := tx.Apply(values)
+	return tx.Warnings, err
+}
+
+// Validate checks every whitespace separated field of value is an integer within the range
+func (r Range) Validate(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty value")
+	}
+	for _, f := range fields {
+		n, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", f)
+		}
+		if n < r.Min || n > r.Max {
+			return fmt.Errorf("%d is out of range [%d, %d]", n, r.Min, r.Max)
+		}
+	}
+	return nil
+}
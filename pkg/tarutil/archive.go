@@ -0,0 +1,350 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// Archive serves random access reads of the entries of an indexed archive, it implements fs.FS
+type Archive struct {
+	index   *Index
+	data    io.ReaderAt
+	closers []io.Closer
+
+	entries map[string]*IndexEntry
+	// children lists the names of the entries in every directory, sorted
+	children map[string][]string
+}
+
+// OpenArchive opens the uncompressed or seekable zstd compressed tar file for random access. The
+// index persisted next to it is used unless it is stale, otherwise the index is built and persisted,
+// failing to persist it is only logged.
+func OpenArchive(tarFilename string) (*Archive, error) {
+
+	tarFile, err := os.Open(tarFilename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+	}
+	info, err := tarFile.Stat()
+	if err != nil {
+		tarFile.Close()
+		return nil, err
+	}
+
+	// indexing a stream without random access would read it all for nothing
+	magic := make([]byte, maxMagicLen)
+	n, _ := tarFile.ReadAt(magic, 0)
+	switch compression := DetectCompression(magic[:n]); compression {
+	case None:
+	case Zstd:
+		// only probes for the seek table, NewArchive opens the reader it serves from
+		zr, err := NewSeekableZstdReader(tarFile, info.Size())
+		if err != nil {
+			tarFile.Close()
+			return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+		}
+		zr.Close()
+	default:
+		tarFile.Close()
+		return nil, fmt.Errorf("%s is %s compressed, which does not support random access", tarFilename, compression)
+	}
+
+	index, err := LoadIndex(IndexPath(tarFilename))
+	if err != nil || index.Stale(info) {
+		if err != nil && !os.IsNotExist(err) {
+			klog.Warningf("rebuild index of %s: %v", tarFilename, err)
+		}
+		if index, err = BuildIndexFile(tarFilename); err != nil {
+			tarFile.Close()
+			return nil, err
+		}
+		if err := index.WriteFile(IndexPath(tarFilename)); err != nil {
+			klog.Warningf("unable to persist index of %s: %v", tarFilename, err)
+		}
+	}
+
+	a, err := NewArchive(tarFile, info.Size(), index)
+	if err != nil {
+		tarFile.Close()
+		return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+	}
+	a.closers = append(a.closers, tarFile)
+	return a, nil
+}
+
+// NewArchive serves the entries of index from the size bytes of r, which is the archive the index
+// was built for
+func NewArchive(r io.ReaderAt, size int64, index *Index) (*Archive, error) {
+
+	a := &Archive{
+		index:    index,
+		data:     r,
+		entries:  make(map[string]*IndexEntry),
+		children: make(map[string][]string),
+	}
+	switch index.Compression {
+	case None:
+	case Zstd:
+		zr, err := NewSeekableZstdReader(r, size)
+		if err != nil {
+			return nil, err
+		}
+		a.data = zr
+		a.closers = append(a.closers, zr)
+	default:
+		return nil, fmt.Errorf("%s compressed archives do not support random access", index.Compression)
+	}
+
+	for i := range index.Entries {
+		e := &index.Entries[i]
+		name, err := cleanName(e.Name)
+		if err != nil || name == "" {
+			continue
+		}
+		a.add(name, e)
+	}
+	for dir := range a.children {
+		sort.Strings(a.children[dir])
+	}
+	return a, nil
+}
+
+func (a *Archive) add(name string, e *IndexEntry) {
+	if _, ok := a.entries[name]; !ok {
+		dir := path.Dir(name)
+		a.children[dir] = append(a.children[dir], name)
+		// parents missing in the archive show up as directories
+		if dir != "." {
+			if _, ok := a.entries[dir]; !ok {
+				a.add(dir, &IndexEntry{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755})
+			}
+		}
+	}
+	// like extraction, a later entry with the same name replaces the earlier one
+	a.entries[name] = e
+}
+
+// Index returns the index the archive is served from
+func (a *Archive) Index() *Index {
+	return a.index
+}
+
+// Entry returns the index entry of name, symlinks and hardlinks are not followed. Names are slash
+// separated and relative like in fs.FS, without a trailing slash for directories.
+func (a *Archive) Entry(name string) (*IndexEntry, bool) {
+	e, ok := a.entries[name]
+	return e, ok
+}
+
+// resolve follows symlinks and hardlinks inside the archive
+func (a *Archive) resolve(name string) (string, *IndexEntry, error) {
+	for i := 0; i <= maxSymlinks; i++ {
+		e, ok := a.entries[name]
+		if !ok {
+			return "", nil, fs.ErrNotExist
+		}
+		var target string
+		switch e.Typeflag {
+		case tar.TypeSymlink:
+			if path.IsAbs(e.Linkname) {
+				return "", nil, fmt.Errorf("symlink %s points to absolute path %s", name, e.Linkname)
+			}
+			target = path.Join(path.Dir(name), e.Linkname)
+		case tar.TypeLink:
+			target = path.Clean(e.Linkname)
+		default:
+			return name, e, nil
+		}
+		if target == ".." || strings.HasPrefix(target, "../") {
+			return "", nil, fmt.Errorf("link %s points to %s outside of the archive", name, e.Linkname)
+		}
+		name = target
+	}
+	return "", nil, fmt.Errorf("too many levels of links resolving %s", name)
+}
+
+// SectionReader returns a reader of the content of the regular file name, it implements io.ReaderAt
+func (a *Archive) SectionReader(name string) (*io.SectionReader, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	_, e, err := a.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if e.Typeflag != tar.TypeReg && e.Typeflag != tar.TypeRegA {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("not a regular file")}
+	}
+	return io.NewSectionReader(a.data, e.Offset, e.Size), nil
+}
+
+// ReadFile implements fs.ReadFileFS, it reads the whole content of the regular file name
+func (a *Archive) ReadFile(name string) ([]byte, error) {
+	sr, err := a.SectionReader(name)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, sr.Size())
+	if _, err := sr.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// Close closes the archive file
+func (a *Archive) Close() error {
+	var err error
+	for _, c := range a.closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Open implements fs.FS, a symlink or hardlink named by name is followed inside the archive, links in
+// the middle of name are not
+func (a *Archive) Open(name string) (fs.File, error) {
+
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &archiveDir{archive: a, name: ".", info: rootInfo{}}, nil
+	}
+	resolved, e, err := a.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	info := &entryInfo{name: path.Base(name), entry: e}
+	switch e.Typeflag {
+	case tar.TypeDir:
+		return &archiveDir{archive: a, name: resolved, info: info}, nil
+	case tar.TypeReg, tar.TypeRegA:
+		return &archiveFile{SectionReader: io.NewSectionReader(a.data, e.Offset, e.Size), info: info}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("unsupported entry type %c", e.Typeflag)}
+}
+
+// ReadDir implements fs.ReadDirFS
+func (a *Archive) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := a.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir, ok := f.(*archiveDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	return dir.ReadDir(-1)
+}
+
+// entryInfo implements fs.FileInfo for an index entry
+type entryInfo struct {
+	name  string
+	entry *IndexEntry
+}
+
+func (i *entryInfo) Name() string {
+	return i.name
+}
+
+func (i *entryInfo) Size() int64 {
+	return i.entry.Size
+}
+
+func (i *entryInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.entry.Mode).Perm() | modeBits(i.entry.Mode)
+	switch i.entry.Typeflag {
+	case tar.TypeDir:
+		mode |= fs.ModeDir
+	case tar.TypeSymlink:
+		mode |= fs.ModeSymlink
+	}
+	return mode
+}
+
+func (i *entryInfo) ModTime() time.Time {
+	return i.entry.ModTime
+}
+
+func (i *entryInfo) IsDir() bool {
+	return i.entry.Typeflag == tar.TypeDir
+}
+
+// Sys returns the *IndexEntry
+func (i *entryInfo) Sys() interface{} {
+	return i.entry
+}
+
+type rootInfo struct{}
+
+func (rootInfo) Name() string       { return "." }
+func (rootInfo) Size() int64        { return 0 }
+func (rootInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (rootInfo) ModTime() time.Time { return time.Time{} }
+func (rootInfo) IsDir() bool        { return true }
+func (rootInfo) Sys() interface{}   { return nil }
+
+type archiveFile struct {
+	*io.SectionReader
+	info fs.FileInfo
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *archiveFile) Close() error {
+	return nil
+}
+
+type archiveDir struct {
+	archive *Archive
+	name    string
+	info    fs.FileInfo
+	offset  int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *archiveDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile, entries are sorted by name
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := d.archive.children[d.name]
+	remaining := names[d.offset:]
+	if n > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(remaining) {
+		remaining = remaining[:n]
+	}
+	entries := make([]fs.DirEntry, 0, len(remaining))
+	for _, name := range remaining {
+		info := &entryInfo{name: path.Base(name), entry: d.archive.entries[name]}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	d.offset += len(remaining)
+	return entries, nil
+}
@@ -0,0 +1,407 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/fileutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ManifestName is the entry holding the sha256sum(1) compatible checksums of all other files
+	ManifestName = "SHA256SUMS"
+
+	DefaultFileMode = 0644
+	DefaultExecMode = 0755
+	DefaultDirMode  = 0755
+)
+
+// Builder collects files from disk and memory and writes them as a tar archive which only depends on
+// the content, names and modes of the files: entries are sorted by name, owners are root, and every
+// entry has the same mtime.
+type Builder struct {
+	// FileMode and ExecMode are the modes of files added from disk without and with an executable
+	// bit, DirMode the mode of all directories
+	FileMode os.FileMode
+	ExecMode os.FileMode
+	DirMode  os.FileMode
+	// ModTime is the mtime of every entry, the unix epoch by default
+	ModTime time.Time
+	// Compression of the written archive
+	Compression Compression
+	// Manifest adds a ManifestName entry as the first entry of the archive
+	Manifest bool
+	// Leading are written before all other entries, in the given order, e.g. a metadata file readers
+	// need before the content
+	Leading []string
+
+	entries map[string]*builderEntry
+}
+
+type builderEntry struct {
+	typeflag byte
+	mode     os.FileMode
+	// data is the content of in-memory files, source the path of files added from disk or fsys
+	data     []byte
+	source   string
+	fsys     fs.FS
+	linkname string
+}
+
+// NewBuilder returns a Builder with the default modes
+func NewBuilder() *Builder {
+	return &Builder{
+		FileMode: DefaultFileMode,
+		ExecMode: DefaultExecMode,
+		DirMode:  DefaultDirMode,
+		ModTime:  time.Unix(0, 0),
+		entries:  make(map[string]*builderEntry),
+	}
+}
+
+// AddFile adds an in-memory file, mode 0 uses FileMode. Adding a name twice replaces the first entry.
+func (b *Builder) AddFile(name string, data []byte, mode os.FileMode) error {
+	return b.add(name, &builderEntry{typeflag: tar.TypeReg, mode: mode, data: data})
+}
+
+// AddFromFS adds the file src of fsys as name, mode 0 uses FileMode. The file is read when the
+// archive is written, so large files are never held in memory.
+func (b *Builder) AddFromFS(name string, fsys fs.FS, src string, mode os.FileMode) error {
+	return b.add(name, &builderEntry{typeflag: tar.TypeReg, mode: mode, source: src, fsys: fsys})
+}
+
+// AddSymlink adds a symlink to linkname
+func (b *Builder) AddSymlink(name, linkname string) error {
+	return b.add(name, &builderEntry{typeflag: tar.TypeSymlink, mode: 0777, linkname: linkname})
+}
+
+// AddDir adds the tree below src as prefix, an empty prefix puts it at the root of the archive.
+// Files are read when the archive is written, symlinks are kept as they are.
+func (b *Builder) AddDir(src, prefix string) error {
+
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		if name == "." || name == "" {
+			return nil
+		}
+
+		switch {
+		case info.IsDir():
+			return b.add(name, &builderEntry{typeflag: tar.TypeDir})
+		case info.Mode().IsRegular():
+			mode := os.FileMode(0)
+			if info.Mode()&0111 != 0 {
+				mode = b.ExecMode
+			}
+			return b.add(name, &builderEntry{typeflag: tar.TypeReg, mode: mode, source: p})
+		case info.Mode()&os.ModeSymlink != 0:
+			linkname, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return b.AddSymlink(name, filepath.ToSlash(linkname))
+		}
+		return fmt.Errorf("%s has unsupported type %s", p, info.Mode().Type())
+	})
+}
+
+func (b *Builder) add(name string, e *builderEntry) error {
+
+	cleaned, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	if cleaned == "" {
+		return fmt.Errorf("invalid entry name %q", name)
+	}
+	if b.Manifest && cleaned == ManifestName {
+		return fmt.Errorf("%s is reserved for the manifest", ManifestName)
+	}
+	if b.entries == nil {
+		b.entries = make(map[string]*builderEntry)
+	}
+	// parents of entries are always part of the archive
+	for dir := path.Dir(cleaned); dir != "."; dir = path.Dir(dir) {
+		if parent, ok := b.entries[dir]; ok && parent.typeflag != tar.TypeDir {
+			return fmt.Errorf("%s is added below %s which is not a directory", cleaned, dir)
+		}
+		b.entries[dir] = &builderEntry{typeflag: tar.TypeDir}
+	}
+	if current, ok := b.entries[cleaned]; ok && current.typeflag == tar.TypeDir && e.typeflag != tar.TypeDir {
+		return fmt.Errorf("%s is already added as a directory", cleaned)
+	}
+	b.entries[cleaned] = e
+	return nil
+}
+
+// Names returns the names of the entries in the order they are written, without the manifest
+func (b *Builder) Names() []string {
+	leading := make(map[string]bool)
+	names := make([]string, 0, len(b.entries))
+	for _, name := range b.Leading {
+		name, _ = cleanName(name)
+		if _, ok := b.entries[name]; ok && !leading[name] {
+			leading[name] = true
+			names = append(names, name)
+		}
+	}
+	rest := make([]string, 0, len(b.entries))
+	for name := range b.entries {
+		if !leading[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+func (b *Builder) open(e *builderEntry) (io.ReadCloser, int64, error) {
+	if e.source == "" {
+		return ioutil.NopCloser(bytes.NewReader(e.data)), int64(len(e.data)), nil
+	}
+	var (
+		f   fs.File
+		err error
+	)
+	if e.fsys != nil {
+		f, err = e.fsys.Open(e.source)
+	} else {
+		f, err = os.Open(e.source)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// FileChecksum describes a regular file of the archive
+type FileChecksum struct {
+	Name   string      `json:"name"`
+	Size   int64       `json:"size"`
+	Mode   os.FileMode `json:"mode"`
+	SHA256 string      `json:"sha256"`
+}
+
+// Files reads every regular file and returns their size, mode and sha256 sorted by name
+func (b *Builder) Files() ([]FileChecksum, error) {
+
+	files := make([]FileChecksum, 0)
+	for _, name := range b.Names() {
+		e := b.entries[name]
+		if e.typeflag != tar.TypeReg {
+			continue
+		}
+		r, _, err := b.open(e)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		size, err := io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s failed", name)
+		}
+		files = append(files, FileChecksum{
+			Name:   name,
+			Size:   size,
+			Mode:   os.FileMode(b.header(name, e, size).Mode),
+			SHA256: hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+	return files, nil
+}
+
+// Link describes a symlink or hardlink of the archive
+type Link struct {
+	Name     string `json:"name"`
+	Linkname string `json:"linkname"`
+	// Hard is set for hardlinks, whose Linkname is the name of another entry
+	Hard bool `json:"hard,omitempty"`
+}
+
+// Links returns the symlinks sorted by name
+func (b *Builder) Links() []Link {
+	links := make([]Link, 0)
+	for _, name := range b.Names() {
+		if e := b.entries[name]; e.typeflag == tar.TypeSymlink {
+			links = append(links, Link{Name: name, Linkname: e.linkname})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Name < links[j].Name })
+	return links
+}
+
+// Checksums returns the sha256 of every file by name
+func (b *Builder) Checksums() (map[string]string, error) {
+	files, err := b.Files()
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string, len(files))
+	for _, f := range files {
+		sums[f.Name] = f.SHA256
+	}
+	return sums, nil
+}
+
+// manifest renders the checksums in sha256sum(1) format, sorted by name
+func manifest(sums map[string]string) []byte {
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", sums[name], name)
+	}
+	return buf.Bytes()
+}
+
+func (b *Builder) header(name string, e *builderEntry, size int64) *tar.Header {
+	header := &tar.Header{
+		Name:     name,
+		Typeflag: e.typeflag,
+		Linkname: e.linkname,
+		ModTime:  b.ModTime,
+		Format:   tar.FormatPAX,
+	}
+	switch e.typeflag {
+	case tar.TypeDir:
+		header.Name += "/"
+		header.Mode = int64(b.DirMode.Perm())
+	case tar.TypeSymlink:
+		header.Mode = 0777
+	default:
+		mode := e.mode
+		if mode == 0 {
+			mode = b.FileMode
+		}
+		header.Mode = int64(mode.Perm())
+		header.Size = size
+	}
+	return header
+}
+
+// WriteTo writes the archive to w, compressed with Compression
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+
+	counter := &countingWriter{w: w}
+	cw, err := CompressWriter(counter, b.Compression)
+	if err != nil {
+		return 0, err
+	}
+	tw := tar.NewWriter(cw)
+
+	if b.Manifest {
+		sums, err := b.Checksums()
+		if err != nil {
+			return counter.n, err
+		}
+		data := manifest(sums)
+		if err := tw.WriteHeader(b.header(ManifestName, &builderEntry{typeflag: tar.TypeReg}, int64(len(data)))); err != nil {
+			return counter.n, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return counter.n, err
+		}
+	}
+
+	for _, name := range b.Names() {
+		e := b.entries[name]
+		if e.typeflag != tar.TypeReg {
+			if err := tw.WriteHeader(b.header(name, e, 0)); err != nil {
+				return counter.n, errors.Wrapf(err, "write %s failed", name)
+			}
+			continue
+		}
+		r, size, err := b.open(e)
+		if err != nil {
+			return counter.n, err
+		}
+		err = tw.WriteHeader(b.header(name, e, size))
+		if err == nil {
+			// a file growing while it is archived fails with tar.ErrWriteTooLong
+			_, err = io.Copy(tw, r)
+		}
+		r.Close()
+		if err != nil {
+			return counter.n, errors.Wrapf(err, "write %s failed", name)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return counter.n, err
+	}
+	if err := cw.Close(); err != nil {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// WriteFile writes the archive to filename, replacing it only once the archive is complete
+func (b *Builder) WriteFile(filename string) error {
+
+	err := fileutil.WriteAtomic(filename, 0644, func(w io.Writer) error {
+		_, err := b.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "write %s failed", filename)
+	}
+	return nil
+}
+
+// ParseManifest parses a sha256sum(1) file into checksums by name
+func ParseManifest(data []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// "<hex>  <name>", binary mode writes "<hex> *<name>"
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid manifest line %d: %q", i+1, line)
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], " "), "*")
+		sums[name] = fields[0]
+	}
+	return sums, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,198 @@
+package tarutil
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression is the compression format wrapping a tar stream
+type Compression string
+
+const (
+	None  Compression = ""
+	Gzip  Compression = "gzip"
+	Zstd  Compression = "zstd"
+	Xz    Compression = "xz"
+	Bzip2 Compression = "bzip2"
+	// SeekableZstd is only used for writing, the stream is detected as Zstd and can be indexed for
+	// random access
+	SeekableZstd Compression = "zstd-seekable"
+)
+
+var magics = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{Xz, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// bzip2 streams start with "BZh" and the block size digit, followed by the magic of the first block
+// or, for empty streams, the end of stream magic
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// maxMagicLen is the number of bytes DetectCompression needs to tell all formats apart
+const maxMagicLen = 10
+
+// DetectCompression returns the compression of a stream starting with header, None if it is not
+// compressed with a known format
+func DetectCompression(header []byte) Compression {
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.compression
+		}
+	}
+	if isBzip2(header) {
+		return Bzip2
+	}
+	return None
+}
+
+// isBzip2 checks the full bzip2 header, "BZh" alone is too likely to start a plain file
+func isBzip2(header []byte) bool {
+	if len(header) < 10 || !bytes.HasPrefix(header, []byte("BZh")) || header[3] < '1' || header[3] > '9' {
+		return false
+	}
+	return bytes.Equal(header[4:10], bzip2BlockMagic) || bytes.Equal(header[4:10], bzip2EndMagic)
+}
+
+// CompressionFromName guesses the compression from the file extension, e.g. ".tar.zst" or ".tgz"
+func CompressionFromName(filename string) Compression {
+	name := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".tgz"):
+		return Gzip
+	case strings.HasSuffix(name, ".zst"), strings.HasSuffix(name, ".tzst"):
+		return Zstd
+	case strings.HasSuffix(name, ".xz"), strings.HasSuffix(name, ".txz"):
+		return Xz
+	case strings.HasSuffix(name, ".bz2"), strings.HasSuffix(name, ".tbz2"):
+		return Bzip2
+	}
+	return None
+}
+
+// Extension returns the file extension of a tar compressed with c, e.g. ".tar.gz"
+func (c Compression) Extension() string {
+	switch c {
+	case Gzip:
+		return ".tar.gz"
+	case Zstd, SeekableZstd:
+		return ".tar.zst"
+	case Xz:
+		return ".tar.xz"
+	case Bzip2:
+		return ".tar.bz2"
+	}
+	return ".tar"
+}
+
+// DecompressReader returns a reader decompressing r, the compression is detected from the magic
+// bytes and returned as well. Uncompressed streams are passed through. Closing the reader releases
+// the decoder, r is not closed.
+func DecompressReader(r io.Reader) (io.ReadCloser, Compression, error) {
+
+	br := bufio.NewReader(r)
+	header, err := br.Peek(maxMagicLen)
+	if err != nil && err != io.EOF {
+		return nil, None, errors.Wrapf(err, "read compression header")
+	}
+
+	compression := DetectCompression(header)
+	switch compression {
+	case Gzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, compression, errors.Wrapf(err, "open gzip stream")
+		}
+		return gr, compression, nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, compression, errors.Wrapf(err, "open zstd stream")
+		}
+		return zr.IOReadCloser(), compression, nil
+	case Xz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, compression, errors.Wrapf(err, "open xz stream")
+		}
+		return io.NopCloser(xr), compression, nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(br)), compression, nil
+	}
+	return io.NopCloser(br), None, nil
+}
+
+// CompressWriter returns a writer compressing to w with c, None writes through. The writer must be
+// closed to flush the compressed stream, w is not closed.
+func CompressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	case SeekableZstd:
+		return NewSeekableZstdWriter(w, DefaultFrameSize)
+	case Xz:
+		return xz.NewWriter(w)
+	case Bzip2:
+		return dsbzip2.NewWriter(w, nil)
+	}
+	return nil, fmt.Errorf("unsupported compression %q", c)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// openTar opens the possibly compressed tar file, closing the returned reader closes the file
+func openTar(tarFilename string) (io.ReadCloser, error) {
+
+	tarFile, err := os.Open(tarFilename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+	}
+	r, _, err := DecompressReader(tarFile)
+	if err != nil {
+		tarFile.Close()
+		return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+	}
+	return &tarReadCloser{Reader: r, closers: []io.Closer{r, tarFile}}, nil
+}
+
+type tarReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (t *tarReadCloser) Close() error {
+	var err error
+	for _, c := range t.closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
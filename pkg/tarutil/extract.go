@@ -0,0 +1,431 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// ExtractOptions controls what Extract writes and guards against tar bombs, zero values mean no limit
+type ExtractOptions struct {
+	// Include and Exclude are path.Match globs matched against the entry name and each of its parent
+	// directories, so "images" selects everything below images/. Globs without a "/" also match the
+	// base names, "*.log" excludes logs in any directory. An empty Include selects all entries, Exclude
+	// wins over Include.
+	Include []string
+	Exclude []string
+
+	// PreserveOwner restores the uid and gid of the entries, which needs root. It implies
+	// PreserveSpecialBits.
+	PreserveOwner bool
+	// PreserveSpecialBits keeps the setuid, setgid and sticky bits of the entries, which are cleared
+	// by default so an untrusted archive cannot plant setuid files
+	PreserveSpecialBits bool
+
+	MaxEntries   int
+	MaxFileSize  int64
+	MaxTotalSize int64
+
+	// Progress is called after every extracted entry
+	Progress func(Progress)
+
+	// VerifyFile is called with the size and hex encoded sha256 of every regular file once it is
+	// written. An error removes the file and aborts the extraction.
+	VerifyFile func(name string, size int64, sha256 string) error
+	// VerifyLink is called for every symlink and hardlink before it is created, an error aborts the
+	// extraction
+	VerifyLink func(link Link) error
+}
+
+func (o ExtractOptions) preserveSpecialBits() bool {
+	return o.PreserveSpecialBits || o.PreserveOwner
+}
+
+// Progress reports how much Extract has written so far
+type Progress struct {
+	// Name is the entry just extracted
+	Name    string
+	Entries int
+	Bytes   int64
+}
+
+// ExtractTo unpacks the tar file, which may be compressed, into dir, see Extract
+func ExtractTo(tarFilename, dir string, opts ExtractOptions) error {
+
+	tarFile, err := os.Open(tarFilename)
+	if err != nil {
+		return errors.Wrapf(err, "open %s failed", tarFilename)
+	}
+	defer tarFile.Close()
+
+	return Extract(tarFile, dir, opts)
+}
+
+// Extract unpacks the possibly compressed tar stream into dir, creating it if needed. Entries with absolute names or
+// names leaving dir are rejected, as are symlinks pointing out of dir, hardlinks to files outside of
+// it and entries which would be written through a symlink. Modes and mtimes are restored, directories
+// get theirs once all entries are written so read-only directories can be filled.
+func Extract(r io.Reader, dir string, opts ExtractOptions) error {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "create %s failed", dir)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	dr, _, err := DecompressReader(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	var (
+		reader   = tar.NewReader(dr)
+		progress Progress
+		dirs     = make([]*tar.Header, 0)
+	)
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read tar file")
+		}
+
+		name, err := cleanName(header.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" || !Selected(name, opts.Include, opts.Exclude) {
+			continue
+		}
+
+		progress.Entries++
+		if opts.MaxEntries > 0 && progress.Entries > opts.MaxEntries {
+			return fmt.Errorf("archive has more than %d entries", opts.MaxEntries)
+		}
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			if opts.MaxFileSize > 0 && header.Size > opts.MaxFileSize {
+				return fmt.Errorf("%s is %d bytes, larger than %d", name, header.Size, opts.MaxFileSize)
+			}
+			if opts.MaxTotalSize > 0 && progress.Bytes+header.Size > opts.MaxTotalSize {
+				return fmt.Errorf("archive is larger than %d bytes", opts.MaxTotalSize)
+			}
+		}
+
+		if opts.VerifyLink != nil && (header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink) {
+			if err := opts.VerifyLink(Link{Name: name, Linkname: header.Linkname, Hard: header.Typeflag == tar.TypeLink}); err != nil {
+				return err
+			}
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := checkParents(dir, name); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				if err := os.Remove(target); err != nil {
+					return errors.Wrapf(err, "create %s failed", name)
+				}
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return errors.Wrapf(err, "create %s failed", name)
+			}
+			dirs = append(dirs, header)
+		case tar.TypeReg, tar.TypeRegA:
+			var (
+				r io.Reader = reader
+				h hash.Hash
+			)
+			if opts.VerifyFile != nil {
+				h = sha256.New()
+				r = io.TeeReader(reader, h)
+			}
+			n, err := extractFile(r, target)
+			progress.Bytes += n
+			if err != nil {
+				return errors.Wrapf(err, "extract %s failed", name)
+			}
+			if h != nil {
+				if err := opts.VerifyFile(name, n, hex.EncodeToString(h.Sum(nil))); err != nil {
+					os.Remove(target)
+					return err
+				}
+			}
+		case tar.TypeSymlink:
+			if err := extractSymlink(dir, name, target, header.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			if err := extractHardlink(dir, name, target, header.Linkname); err != nil {
+				return err
+			}
+		default:
+			// devices and fifos need privileges and have no place in a bundle
+			klog.V(4).Infof("skip %s of unsupported type %c", name, header.Typeflag)
+			progress.Entries--
+			continue
+		}
+
+		if opts.PreserveOwner {
+			if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+				return errors.Wrapf(err, "chown %s failed", name)
+			}
+		}
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			if err := restoreMetadata(target, header, opts.preserveSpecialBits()); err != nil {
+				return errors.Wrapf(err, "restore metadata of %s failed", name)
+			}
+		}
+
+		if opts.Progress != nil {
+			progress.Name = name
+			opts.Progress(progress)
+		}
+	}
+
+	// deepest directories first, so restoring a parent's mtime is not undone by its children
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name, _ := cleanName(dirs[i].Name)
+		if err := restoreMetadata(filepath.Join(dir, filepath.FromSlash(name)), dirs[i], opts.preserveSpecialBits()); err != nil {
+			return errors.Wrapf(err, "restore metadata of %s failed", name)
+		}
+	}
+	return nil
+}
+
+// cleanName returns the slash separated name of an entry relative to the extraction directory,
+// empty for the directory itself
+func cleanName(name string) (string, error) {
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("entry %s has an absolute path", name)
+	}
+	cleaned := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("entry %s leaves the extraction directory", name)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// Selected returns true if name or one of its parents matches include and none matches exclude, as
+// ExtractOptions selects entries
+func Selected(name string, include, exclude []string) bool {
+	if matchAny(name, exclude) {
+		return false
+	}
+	return len(include) == 0 || matchAny(name, include)
+}
+
+func matchAny(name string, patterns []string) bool {
+	for p := name; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if !strings.Contains(pattern, "/") {
+				if ok, _ := path.Match(pattern, path.Base(p)); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// checkParents creates the missing parents of name and fails if one of them is not a directory,
+// writing through a symlink extracted earlier could leave dir
+func checkParents(dir, name string) error {
+	current := dir
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(current, 0755); err != nil {
+				return errors.Wrapf(err, "create %s failed", current)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("entry %s is written through %s which is not a directory", name, current)
+		}
+	}
+	return nil
+}
+
+// replace removes whatever is at target unless it is a directory, so the new entry never writes
+// through an existing symlink
+func replace(target string) error {
+	info, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", target)
+	}
+	return os.Remove(target)
+}
+
+func extractFile(r io.Reader, target string) (int64, error) {
+	if err := replace(target); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	// the header size was checked against the limits, the reader never returns more
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+func extractSymlink(dir, name, target, linkname string) error {
+	if path.IsAbs(linkname) || filepath.IsAbs(linkname) {
+		return fmt.Errorf("symlink %s points to absolute path %s", name, linkname)
+	}
+	if _, err := resolveInside(dir, path.Dir(name)+"/"+strings.ReplaceAll(linkname, "\\", "/"), 0); err != nil {
+		return fmt.Errorf("symlink %s points to %s outside of the extraction directory", name, linkname)
+	}
+	if err := replace(target); err != nil {
+		return errors.Wrapf(err, "extract %s failed", name)
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return errors.Wrapf(err, "extract %s failed", name)
+	}
+	return nil
+}
+
+// maxSymlinks bounds the symlinks followed by resolveInside, like the kernel's MAXSYMLINKS
+const maxSymlinks = 40
+
+// resolveInside resolves the slash separated path relative to dir component by component, following
+// the symlinks already extracted, and fails if it ever leaves dir. Resolving "s/../x" lexically is
+// not enough when s is a symlink.
+func resolveInside(dir, name string, depth int) (string, error) {
+	if depth > maxSymlinks {
+		return "", fmt.Errorf("too many levels of symbolic links")
+	}
+	resolved := make([]string, 0)
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", fmt.Errorf("%s leaves %s", name, dir)
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		resolved = append(resolved, part)
+		current := strings.Join(resolved, "/")
+		info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(current)))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		linkname, err := os.Readlink(filepath.Join(dir, filepath.FromSlash(current)))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(linkname) || filepath.IsAbs(linkname) {
+			return "", fmt.Errorf("%s points to absolute path %s", current, linkname)
+		}
+		target, err := resolveInside(dir, path.Dir(current)+"/"+linkname, depth+1)
+		if err != nil {
+			return "", err
+		}
+		resolved = strings.Split(target, "/")
+		if target == "" {
+			resolved = resolved[:0]
+		}
+	}
+	return strings.Join(resolved, "/"), nil
+}
+
+func extractHardlink(dir, name, target, linkname string) error {
+	if path.IsAbs(linkname) || filepath.IsAbs(linkname) {
+		return fmt.Errorf("hardlink %s points to absolute path %s", name, linkname)
+	}
+	source, err := resolveInside(dir, strings.ReplaceAll(linkname, "\\", "/"), 0)
+	if err != nil || source == "" {
+		return fmt.Errorf("hardlink %s points to %s outside of the extraction directory", name, linkname)
+	}
+	sourcePath := filepath.Join(dir, filepath.FromSlash(source))
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return errors.Wrapf(err, "hardlink %s points to %s which is not extracted", name, linkname)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hardlink %s points to %s which is not a regular file", name, linkname)
+	}
+	if err := replace(target); err != nil {
+		return errors.Wrapf(err, "extract %s failed", name)
+	}
+	if err := os.Link(sourcePath, target); err != nil {
+		return errors.Wrapf(err, "extract %s failed", name)
+	}
+	return nil
+}
+
+func restoreMetadata(target string, header *tar.Header, specialBits bool) error {
+	mode := os.FileMode(header.Mode).Perm()
+	if specialBits {
+		mode |= modeBits(header.Mode)
+	}
+	if err := os.Chmod(target, mode); err != nil {
+		return err
+	}
+	if header.ModTime.IsZero() {
+		return nil
+	}
+	atime := header.AccessTime
+	if atime.IsZero() {
+		atime = time.Now()
+	}
+	return os.Chtimes(target, atime, header.ModTime)
+}
+
+// modeBits converts the setuid, setgid and sticky bits of a tar mode to their os.FileMode flags
+func modeBits(mode int64) os.FileMode {
+	var m os.FileMode
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
@@ -0,0 +1,72 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractSpecialBits(t *testing.T) {
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range []*tar.Header{
+		{Name: "shared/", Typeflag: tar.TypeDir, Mode: 01777},
+		{Name: "bin/su", Typeflag: tar.TypeReg, Mode: 06755, Size: 2},
+	} {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tw.Write([]byte("su"))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      ExtractOptions
+		needsRoot bool
+		wantFile  os.FileMode
+		wantDir   os.FileMode
+	}{
+		{name: "masked by default", wantFile: 0755, wantDir: 0777},
+		{
+			name:     "preserve special bits",
+			opts:     ExtractOptions{PreserveSpecialBits: true},
+			wantFile: 0755 | os.ModeSetuid | os.ModeSetgid,
+			wantDir:  0777 | os.ModeSticky,
+		},
+		{
+			name:      "preserve owner",
+			opts:      ExtractOptions{PreserveOwner: true},
+			needsRoot: true,
+			wantFile:  0755 | os.ModeSetuid | os.ModeSetgid,
+			wantDir:   0777 | os.ModeSticky,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsRoot && os.Geteuid() != 0 {
+				t.Skip("chown needs root")
+			}
+			dir := t.TempDir()
+			if err := Extract(bytes.NewReader(buf.Bytes()), dir, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]os.FileMode{"bin/su": tt.wantFile, "shared": tt.wantDir | os.ModeDir} {
+				info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode() != want {
+					t.Errorf("%s: got mode %v, want %v", name, info.Mode(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/QQGoblin/go-sdk/pkg/fileutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	// IndexExt is appended to the archive path to find its persisted index
+	IndexExt = ".idx"
+
+	indexVersion = 1
+)
+
+// IndexEntry is the header of an archive entry and where its content starts in the uncompressed tar
+type IndexEntry struct {
+	Name     string    `json:"name"`
+	Typeflag byte      `json:"type"`
+	Linkname string    `json:"linkname,omitempty"`
+	Size     int64     `json:"size"`
+	Mode     int64     `json:"mode"`
+	Uid      int       `json:"uid,omitempty"`
+	Gid      int       `json:"gid,omitempty"`
+	ModTime  time.Time `json:"modTime"`
+	Offset   int64     `json:"offset"`
+}
+
+// Index lists the entries of an uncompressed or seekable zstd compressed archive with their offsets
+type Index struct {
+	Version     int         `json:"version"`
+	Compression Compression `json:"compression,omitempty"`
+	// ArchiveSize and ArchiveModTime identify the archive the index was built for, a changed archive
+	// makes the index stale
+	ArchiveSize    int64        `json:"archiveSize"`
+	ArchiveModTime time.Time    `json:"archiveModTime"`
+	Entries        []IndexEntry `json:"entries"`
+}
+
+// IndexPath returns where the index of the archive is persisted
+func IndexPath(tarFilename string) string {
+	return tarFilename + IndexExt
+}
+
+// countingReader counts the bytes read, tar.Reader consumes headers block by block without reading
+// ahead, so after Next the count is the offset of the entry content
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// BuildIndex reads the tar stream once and records the offset of every entry. Compressed streams are
+// indexed by their decompressed offsets, which only allows random access for seekable zstd.
+func BuildIndex(r io.Reader) (*Index, error) {
+
+	dr, compression, err := DecompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	counter := &countingReader{r: bufio.NewReader(dr)}
+	reader := tar.NewReader(noBuffer{counter})
+	index := &Index{Version: indexVersion, Compression: compression, Entries: make([]IndexEntry, 0)}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "read tar file")
+		}
+		if isSparse(header) {
+			return nil, fmt.Errorf("sparse entry %s can not be indexed", header.Name)
+		}
+		index.Entries = append(index.Entries, IndexEntry{
+			Name:     header.Name,
+			Typeflag: header.Typeflag,
+			Linkname: header.Linkname,
+			Size:     header.Size,
+			Mode:     header.Mode,
+			Uid:      header.Uid,
+			Gid:      header.Gid,
+			ModTime:  header.ModTime,
+			Offset:   counter.n,
+		})
+	}
+}
+
+// isSparse returns true for the GNU and PAX sparse formats, the content of sparse entries is not
+// stored contiguously
+func isSparse(header *tar.Header) bool {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// noBuffer hides io.WriterTo and other optional interfaces, so reads go through the counter
+type noBuffer struct {
+	r io.Reader
+}
+
+func (n noBuffer) Read(p []byte) (int, error) {
+	return n.r.Read(p)
+}
+
+// BuildIndexFile builds the index of the tar file
+func BuildIndexFile(tarFilename string) (*Index, error) {
+
+	tarFile, err := os.Open(tarFilename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+	}
+	defer tarFile.Close()
+
+	info, err := tarFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	index, err := BuildIndex(tarFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "index %s failed", tarFilename)
+	}
+	index.ArchiveSize, index.ArchiveModTime = info.Size(), info.ModTime()
+	return index, nil
+}
+
+// WriteFile persists the index to filename
+func (ix *Index) WriteFile(filename string) error {
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(filename, data, 0644)
+}
+
+// LoadIndex reads an index persisted with WriteFile
+func LoadIndex(filename string) (*Index, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, errors.Wrapf(err, "invalid index %s", filename)
+	}
+	if index.Version != indexVersion {
+		return nil, fmt.Errorf("index %s has unsupported version %d", filename, index.Version)
+	}
+	return index, nil
+}
+
+// Stale returns true if the archive info does not match the one the index was built for
+func (ix *Index) Stale(info os.FileInfo) bool {
+	return ix.ArchiveSize != info.Size() || !ix.ArchiveModTime.Equal(info.ModTime())
+}
@@ -0,0 +1,236 @@
+package tarutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Seekable zstd streams are a sequence of independent zstd frames followed by a skippable frame with
+// a seek table listing the compressed and decompressed size of each, see
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md.
+// Regular zstd decoders ignore the seek table.
+const (
+	// DefaultFrameSize is the decompressed size of the frames of a seekable zstd stream
+	DefaultFrameSize = 4 << 20
+
+	skippableMagic        = 0x184D2A5E
+	seekableMagic         = 0x8F92EAB1
+	seekTableFooterSize   = 9
+	seekTableChecksumFlag = 1 << 7
+)
+
+// SeekableZstdWriter compresses to independent frames of FrameSize bytes and appends the seek table
+// on Close
+type SeekableZstdWriter struct {
+	w         io.Writer
+	encoder   *zstd.Encoder
+	frameSize int
+	buf       []byte
+	frames    []seekFrame
+}
+
+type seekFrame struct {
+	compressed   uint32
+	decompressed uint32
+}
+
+// NewSeekableZstdWriter returns a writer producing a seekable zstd stream, frameSize 0 uses
+// DefaultFrameSize. The writer must be closed to write the seek table, w is not closed.
+func NewSeekableZstdWriter(w io.Writer, frameSize int) (*SeekableZstdWriter, error) {
+	if frameSize <= 0 {
+		frameSize = DefaultFrameSize
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SeekableZstdWriter{w: w, encoder: encoder, frameSize: frameSize}, nil
+}
+
+func (s *SeekableZstdWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := s.frameSize - len(s.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(s.buf) == s.frameSize {
+			if err := s.flushFrame(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (s *SeekableZstdWriter) flushFrame() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	frame := s.encoder.EncodeAll(s.buf, nil)
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	s.frames = append(s.frames, seekFrame{compressed: uint32(len(frame)), decompressed: uint32(len(s.buf))})
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Close writes the last frame and the seek table
+func (s *SeekableZstdWriter) Close() error {
+	if err := s.flushFrame(); err != nil {
+		return err
+	}
+	s.encoder.Close()
+
+	tableSize := len(s.frames)*8 + seekTableFooterSize
+	table := make([]byte, 8+tableSize)
+	binary.LittleEndian.PutUint32(table[0:], skippableMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(tableSize))
+	for i, f := range s.frames {
+		binary.LittleEndian.PutUint32(table[8+i*8:], f.compressed)
+		binary.LittleEndian.PutUint32(table[12+i*8:], f.decompressed)
+	}
+	// the descriptor byte stays 0, frames carry no checksums in the table
+	footer := table[8+len(s.frames)*8:]
+	binary.LittleEndian.PutUint32(footer[0:], uint32(len(s.frames)))
+	binary.LittleEndian.PutUint32(footer[5:], seekableMagic)
+	_, err := s.w.Write(table)
+	return err
+}
+
+// SeekableZstdReader serves random access reads of the decompressed content of a seekable zstd
+// stream, decoding only the frames covering a read. It is safe for concurrent use.
+type SeekableZstdReader struct {
+	r       io.ReaderAt
+	decoder *zstd.Decoder
+	// compressedOffsets and offsets are the start of every frame in the compressed and decompressed
+	// stream, with the total sizes as last element
+	compressedOffsets []int64
+	offsets           []int64
+
+	mu          sync.Mutex
+	cachedFrame int
+	cached      []byte
+}
+
+// NewSeekableZstdReader reads the seek table at the end of the size bytes of r, it fails if the
+// stream has none
+func NewSeekableZstdReader(r io.ReaderAt, size int64) (*SeekableZstdReader, error) {
+
+	if size < seekTableFooterSize+8 {
+		return nil, fmt.Errorf("zstd stream has no seek table")
+	}
+	footer := make([]byte, seekTableFooterSize)
+	if _, err := r.ReadAt(footer, size-seekTableFooterSize); err != nil {
+		return nil, errors.Wrapf(err, "read seek table")
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, fmt.Errorf("zstd stream has no seek table")
+	}
+	frames := int64(binary.LittleEndian.Uint32(footer[0:]))
+	entrySize := int64(8)
+	if footer[4]&seekTableChecksumFlag != 0 {
+		entrySize = 12
+	}
+	tableSize := frames*entrySize + seekTableFooterSize
+	if tableSize+8 > size {
+		return nil, fmt.Errorf("seek table of %d frames exceeds the stream", frames)
+	}
+
+	table := make([]byte, tableSize+8)
+	if _, err := r.ReadAt(table, size-tableSize-8); err != nil {
+		return nil, errors.Wrapf(err, "read seek table")
+	}
+	if binary.LittleEndian.Uint32(table[0:]) != skippableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, fmt.Errorf("invalid seek table header")
+	}
+
+	s := &SeekableZstdReader{
+		r:                 r,
+		compressedOffsets: make([]int64, 1, frames+1),
+		offsets:           make([]int64, 1, frames+1),
+		cachedFrame:       -1,
+	}
+	for i := int64(0); i < frames; i++ {
+		entry := table[8+i*entrySize:]
+		s.compressedOffsets = append(s.compressedOffsets, s.compressedOffsets[i]+int64(binary.LittleEndian.Uint32(entry[0:])))
+		s.offsets = append(s.offsets, s.offsets[i]+int64(binary.LittleEndian.Uint32(entry[4:])))
+	}
+	if s.compressedOffsets[frames] != size-tableSize-8 {
+		return nil, fmt.Errorf("seek table does not match the stream size")
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	s.decoder = decoder
+	return s, nil
+}
+
+// Size returns the decompressed size
+func (s *SeekableZstdReader) Size() int64 {
+	return s.offsets[len(s.offsets)-1]
+}
+
+// ReadAt reads decompressed content at off
+func (s *SeekableZstdReader) ReadAt(p []byte, off int64) (int, error) {
+
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	n := 0
+	for n < len(p) {
+		if off >= s.Size() {
+			return n, io.EOF
+		}
+		frame := sort.Search(len(s.offsets)-1, func(i int) bool { return s.offsets[i+1] > off })
+		data, err := s.frame(frame)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], data[off-s.offsets[frame]:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (s *SeekableZstdReader) frame(i int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cachedFrame == i {
+		return s.cached, nil
+	}
+	compressed := make([]byte, s.compressedOffsets[i+1]-s.compressedOffsets[i])
+	if _, err := s.r.ReadAt(compressed, s.compressedOffsets[i]); err != nil {
+		return nil, errors.Wrapf(err, "read zstd frame %d", i)
+	}
+	// callers copy from the returned frame without the lock, it must not be reused
+	data, err := s.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decode zstd frame %d", i)
+	}
+	if int64(len(data)) != s.offsets[i+1]-s.offsets[i] {
+		return nil, fmt.Errorf("zstd frame %d has %d bytes, the seek table lists %d", i, len(data), s.offsets[i+1]-s.offsets[i])
+	}
+	s.cachedFrame, s.cached = i, data
+	return data, nil
+}
+
+// Close releases the decoder
+func (s *SeekableZstdReader) Close() error {
+	s.decoder.Close()
+	return nil
+}
@@ -6,21 +6,39 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"io"
-	"os"
 )
 
+// ReadAllHeaders lists the headers of the tar file, which may be compressed
 func ReadAllHeaders(tarFilename string) ([]*tar.Header, error) {
 
-	tarFile, err := os.Open(tarFilename)
+	tarFile, err := openTar(tarFilename)
 	if err != nil {
-		return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+		return nil, err
 	}
 	defer tarFile.Close()
 
-	reader := tar.NewReader(tarFile)
+	return readAllHeaders(tarFile)
+}
+
+// ReadAllHeadersFrom lists the headers of the tar stream, which may be compressed
+func ReadAllHeadersFrom(r io.Reader) ([]*tar.Header, error) {
+
+	dr, _, err := DecompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	return readAllHeaders(dr)
+}
+
+func readAllHeaders(r io.Reader) ([]*tar.Header, error) {
+
+	reader := tar.NewReader(r)
 
 	var (
 		header      *tar.Header
+		err         error
 		fileHeaders = make([]*tar.Header, 0)
 	)
 
@@ -38,24 +56,53 @@ func ReadAllHeaders(tarFilename string) ([]*tar.Header, error) {
 	return fileHeaders, nil
 }
 
+// ExtractedByName reads the file named extractedName from the tar file, which may be compressed
 func ExtractedByName(tarFilename, extractedName string) ([]byte, error) {
 
-	tarFile, err := os.Open(tarFilename)
+	tarFile, err := openTar(tarFilename)
 	if err != nil {
-		return nil, errors.Wrapf(err, "open %s failed", tarFilename)
+		return nil, err
 	}
 	defer tarFile.Close()
 
-	reader := tar.NewReader(tarFile)
+	data, err := extractedByName(tarFile, extractedName)
+	if err == errNotFound {
+		return nil, fmt.Errorf("file %s is not found in %s", extractedName, tarFilename)
+	}
+	return data, err
+}
+
+// ExtractedByNameFrom reads the file named extractedName from the tar stream, which may be compressed
+func ExtractedByNameFrom(r io.Reader, extractedName string) ([]byte, error) {
+
+	dr, _, err := DecompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dr.Close()
+
+	data, err := extractedByName(dr, extractedName)
+	if err == errNotFound {
+		return nil, fmt.Errorf("file %s is not found", extractedName)
+	}
+	return data, err
+}
+
+var errNotFound = errors.New("not found")
+
+func extractedByName(r io.Reader, extractedName string) ([]byte, error) {
+
+	reader := tar.NewReader(r)
 
 	var (
 		header *tar.Header
+		err    error
 	)
 
 	for {
 		header, err = reader.Next()
 		if err == io.EOF {
-			return nil, fmt.Errorf("file %s is not found in %s", extractedName, tarFilename)
+			return nil, errNotFound
 		}
 		if err != nil {
 			return nil, errors.Wrapf(err, "read tar file")